@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE wallet_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    wallet_id UUID NOT NULL REFERENCES wallets (id),
+    operation_type VARCHAR(16) NOT NULL,
+    amount BIGINT NOT NULL,
+    balance_after BIGINT NOT NULL,
+    request_id VARCHAR(64),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX wallet_transactions_wallet_id_idx ON wallet_transactions (wallet_id, id);
+
+CREATE FUNCTION wallet_transactions_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'wallet_transactions is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER wallet_transactions_append_only
+    BEFORE UPDATE OR DELETE ON wallet_transactions
+    FOR EACH ROW EXECUTE FUNCTION wallet_transactions_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE wallet_transactions;
+DROP FUNCTION wallet_transactions_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE wallets
+    ADD COLUMN owner_id VARCHAR(255),
+    ADD COLUMN external_ref VARCHAR(255),
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'ACTIVE',
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN closed_at TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX wallets_external_ref_idx ON wallets (external_ref) WHERE external_ref IS NOT NULL;
+CREATE INDEX wallets_owner_id_idx ON wallets (owner_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX wallets_owner_id_idx;
+DROP INDEX wallets_external_ref_idx;
+ALTER TABLE wallets
+    DROP COLUMN owner_id,
+    DROP COLUMN external_ref,
+    DROP COLUMN status,
+    DROP COLUMN created_at,
+    DROP COLUMN closed_at;
+-- +goose StatementEnd
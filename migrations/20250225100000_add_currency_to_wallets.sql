@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Wallets created before currencies were introduced hold roubles.
+ALTER TABLE wallets
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB' CHECK (currency ~ '^[A-Z]{3}$');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE wallets
+    DROP COLUMN currency;
+-- +goose StatementEnd
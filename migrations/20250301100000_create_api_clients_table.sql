@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- key_hash is the hex SHA-256 of the API key; the key itself is never stored.
+-- A NULL wallet_ids lets the client reach every wallet its scopes allow. Clients are
+-- added by hand, e.g.
+--   INSERT INTO api_clients (name, key_hash, scopes)
+--   VALUES ('k6', encode(sha256('<api key>'), 'hex'), '{deposit,withdraw,read-balance}');
+CREATE TABLE api_clients (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    wallet_ids UUID[],
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
+
+ALTER TABLE wallet_transactions
+    ADD COLUMN client_id UUID REFERENCES api_clients (id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE wallet_transactions
+    DROP COLUMN client_id;
+
+DROP TABLE api_clients;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Clients with a signing_secret must sign wallet operations with HMAC-SHA256, e.g.
+--   UPDATE api_clients SET signing_secret = '<shared secret>' WHERE name = 'partner';
+-- The secret has to be kept in the clear to verify signatures.
+ALTER TABLE api_clients
+    ADD COLUMN signing_secret TEXT;
+
+-- request_nonces holds the X-Nonce values seen within the clock-skew window, so that
+-- a captured request cannot be replayed.
+CREATE TABLE request_nonces (
+    client_id UUID NOT NULL REFERENCES api_clients (id) ON DELETE CASCADE,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, nonce)
+);
+
+CREATE INDEX request_nonces_expires_at_idx ON request_nonces (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE request_nonces;
+
+ALTER TABLE api_clients
+    DROP COLUMN signing_secret;
+-- +goose StatementEnd
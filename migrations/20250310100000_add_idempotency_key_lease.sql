@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- locked_until is the lease of a request that is still in progress. A key whose
+-- request never finished, e.g. because the service crashed, can be reclaimed once
+-- the lease has run out instead of staying locked until expires_at.
+ALTER TABLE idempotency_keys
+    ADD COLUMN locked_until TIMESTAMPTZ NOT NULL DEFAULT now();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE idempotency_keys
+    DROP COLUMN locked_until;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Together with wallet_transactions.client_id these record the API client behind
+-- every operation. A NULL client is an end user or the service itself, e.g. when a
+-- hold expires.
+ALTER TABLE wallets
+    ADD COLUMN client_id UUID REFERENCES api_clients (id),
+    ADD COLUMN closed_by_client_id UUID REFERENCES api_clients (id);
+
+ALTER TABLE wallet_holds
+    ADD COLUMN client_id UUID REFERENCES api_clients (id),
+    ADD COLUMN updated_by_client_id UUID REFERENCES api_clients (id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE wallet_holds
+    DROP COLUMN updated_by_client_id,
+    DROP COLUMN client_id;
+
+ALTER TABLE wallets
+    DROP COLUMN closed_by_client_id,
+    DROP COLUMN client_id;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- principal is the API client ID, or user:<subject> for an end user. Each principal
+-- has its own key space, so a key reused by someone else is never a conflict.
+-- Existing rows get an empty principal and are never replayed; they expire as usual.
+ALTER TABLE idempotency_keys
+    ADD COLUMN principal VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE idempotency_keys
+    DROP CONSTRAINT idempotency_keys_pkey,
+    ADD PRIMARY KEY (principal, key);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM idempotency_keys a
+    USING idempotency_keys b
+    WHERE a.key = b.key AND a.principal > b.principal;
+
+ALTER TABLE idempotency_keys
+    DROP CONSTRAINT idempotency_keys_pkey,
+    ADD PRIMARY KEY (key);
+
+ALTER TABLE idempotency_keys
+    DROP COLUMN principal;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The subject of the bearer token behind an operation. End users have no row in
+-- api_clients, so their client_id columns stay NULL. Users cannot create or close
+-- wallets, so only the ledger and holds record them.
+ALTER TABLE wallet_transactions
+    ADD COLUMN user_id VARCHAR(255);
+
+ALTER TABLE wallet_holds
+    ADD COLUMN user_id VARCHAR(255),
+    ADD COLUMN updated_by_user_id VARCHAR(255);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE wallet_holds
+    DROP COLUMN updated_by_user_id,
+    DROP COLUMN user_id;
+
+ALTER TABLE wallet_transactions
+    DROP COLUMN user_id;
+-- +goose StatementEnd
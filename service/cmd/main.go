@@ -2,45 +2,145 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"log"
 	"net/http"
+	"os/signal"
 	"service/internal/initenv"
+	"service/internal/logger"
+	"service/internal/metrics"
 	"service/internal/middleware"
+	"service/internal/tracing"
 	"service/internal/wallet"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/xlab/closer"
+)
+
+const (
+	defaultShutdownTimeout = 15 * time.Second
+	readHeaderTimeout      = 5 * time.Second
 )
 
 func main() {
+	configFlag := flag.String("config", "", "path to the YAML config file (default $WALLET_CONFIG or internal/config/config.yaml)")
+	flag.Parse()
 
 	ctx := context.Background()
-	lg, cfgAdr, err := initenv.Start(ctx)
+	lg, cfgAdr, err := initenv.Start(ctx, initenv.ConfigPath(*configFlag))
+	if err != nil {
+		// There is no logger without a valid config.
+		log.Fatalf("Error starting initenv: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfgAdr.Otlp_endpoint, cfgAdr.Service_name)
 	if err != nil {
-		lg.FatalCtx(ctx, "Error starting initenv", err)
+		lg.FatalCtx(ctx, "Error starting tracing", err)
 	}
-	defer closer.Close()
 
 	router := chi.NewRouter()
 	walletHandler := wallet.NewHandler(lg, ctx, cfgAdr)
 
-	router.Use(middleware.ContextRequestMiddleware)
-	router.Post("/api/v1/wallet", walletHandler.HandleWalletOperation)
-	router.Get("/api/v1/balance/{id}", walletHandler.GetWalletBalance)
-
-	closer.Bind(func() {
+	trustedProxies, err := middleware.ParseTrustedProxies(cfgAdr.Trusted_proxies)
+	if err != nil {
+		lg.FatalCtx(ctx, "Error parsing trusted proxies", err)
+	}
+	router.Use(middleware.RequestID(trustedProxies))
+	router.Use(middleware.AccessLog(lg))
+	router.Use(middleware.Timeout(cfgAdr.Request_timeout))
+	router.Use(tracing.Middleware)
+	router.Use(metrics.Middleware)
+	// Innermost, so that tracing, metrics and the access log see the 500.
+	router.Use(middleware.Recover(lg))
+	router.Handle("/metrics", metrics.Handler())
+	router.Get("/healthz", walletHandler.Healthz)
+	router.Get("/readyz", walletHandler.Readyz)
 
-		walletHandler.Close()
-		time.Sleep(3 * time.Second)
+	router.Group(func(r chi.Router) {
+		r.Use(walletHandler.Authenticate)
+		read := r.With(walletHandler.RequireScope(wallet.SCOPE_READ_BALANCE))
+		withdraw := r.With(walletHandler.RequireScope(wallet.SCOPE_WITHDRAW))
+		admin := r.With(walletHandler.RequireScope(wallet.SCOPE_ADMIN))
 
-		lg.InfoCtx(ctx, "Database connection closed")
+		// The scope of an operation depends on its type, so these check it themselves.
+		r.Post("/api/v1/wallet", walletHandler.VerifySignature(walletHandler.Idempotent(walletHandler.HandleWalletOperation)))
+		r.Post("/api/v1/wallet/batch", walletHandler.VerifySignature(walletHandler.Idempotent(walletHandler.HandleBatchOperation)))
+		read.Get("/api/v1/balance/{id}", walletHandler.GetWalletBalance)
+		read.Get("/api/v1/wallet/{id}/transactions", walletHandler.GetWalletTransactions)
+		withdraw.Post("/api/v1/wallet/{id}/holds", walletHandler.Idempotent(walletHandler.CreateHold))
+		withdraw.Post("/api/v1/wallet/{id}/holds/{holdId}/capture", walletHandler.Idempotent(walletHandler.CaptureHold))
+		withdraw.Post("/api/v1/wallet/{id}/holds/{holdId}/void", walletHandler.Idempotent(walletHandler.VoidHold))
+		admin.Post("/api/v1/wallets", walletHandler.Idempotent(walletHandler.CreateWallet))
+		read.Get("/api/v1/wallets/{id}", walletHandler.GetWallet)
+		admin.Delete("/api/v1/wallets/{id}", walletHandler.CloseWallet)
 	})
 
-	err = http.ListenAndServe(cfgAdr.APP_ADR, router)
-	if err != nil {
-		lg.FatalCtx(ctx, "Error starting server", err)
+	server := &http.Server{Addr: cfgAdr.APP_ADR, Handler: router, ReadHeaderTimeout: readHeaderTimeout}
+
+	// Admin endpoints listen on their own address so they are never reachable through
+	// the public listener, and still require the admin scope.
+	var adminServer *http.Server
+	if cfgAdr.Admin_adr != "" {
+		adminRouter := chi.NewRouter()
+		adminRouter.Use(middleware.RequestID(trustedProxies))
+		adminRouter.Use(middleware.AccessLog(lg))
+		adminRouter.Use(middleware.Recover(lg))
+		adminRouter.Use(walletHandler.Authenticate)
+		adminRouter.Use(walletHandler.RequireScope(wallet.SCOPE_ADMIN))
+		adminRouter.Get("/admin/log-level", logger.LevelHandler(lg))
+		adminRouter.Put("/admin/log-level", logger.LevelHandler(lg))
+		adminServer = &http.Server{Addr: cfgAdr.Admin_adr, Handler: adminRouter, ReadHeaderTimeout: readHeaderTimeout}
+	}
+
+	sigCtx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	if adminServer != nil {
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				lg.ErrorCtx(ctx, "Admin server stopped with error: "+err.Error())
+			}
+		}()
 	}
 	lg.InfoCtx(ctx, "Server started")
 
-	closer.Hold()
+	select {
+	case err = <-serverErr:
+		// The pool is still open here; release it before exiting.
+		walletHandler.Close()
+		lg.FatalCtx(ctx, "Error starting server", err)
+	case <-sigCtx.Done():
+		cancel()
+	}
+
+	// Stop accepting connections and let in-flight wallet operations finish before
+	// the pool is closed under them.
+	timeout := cfgAdr.Shutdown_timeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	lg.InfoCtx(ctx, "Shutting down server...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(ctx, timeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		lg.ErrorCtx(ctx, "Server shutdown deadline exceeded, closing remaining connections")
+		server.Close()
+	}
+	if adminServer != nil {
+		adminServer.Shutdown(shutdownCtx)
+	}
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		lg.ErrorCtx(ctx, "Server stopped with error: "+err.Error())
+	}
+
+	walletHandler.Close()
+	lg.InfoCtx(ctx, "Database connection closed")
+	shutdownTracing(shutdownCtx)
+	lg.Close()
 }
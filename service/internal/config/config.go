@@ -1,17 +1,82 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
 	"service/internal/logger"
+	"service/internal/middleware"
+	"strconv"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+// EnvPrefix prefixes the environment variables that override config fields: the
+// field tagged `yaml:"database_url"` is overridden by WALLET_DATABASE_URL.
+const EnvPrefix = "WALLET_"
+
+// Defaults of the settings that are left unset.
+const (
+	DefaultIdempotencyTTL   = 24 * time.Hour
+	DefaultIdempotencyLease = time.Minute
+	DefaultSignatureSkew    = 5 * time.Minute
+)
+
 type ConfigAdr struct {
 	Database_url string `yaml:"database_url"`
 	APP_ADR      string `yaml:"app_adr"`
+	// Admin_adr is the host:port of the listener serving /admin endpoints; keep it
+	// off the public network. Empty disables them.
+	Admin_adr string `yaml:"admin_adr"`
+	// Idempotency_ttl is how long a stored Idempotency-Key response is replayed.
+	Idempotency_ttl time.Duration `yaml:"idempotency_ttl"`
+	// Idempotency_lease is how long an unfinished request holds its Idempotency-Key
+	// before a retry may reclaim it. It must exceed request_timeout.
+	Idempotency_lease time.Duration `yaml:"idempotency_lease"`
+	// Signature_max_skew is how far X-Timestamp of a signed request may be from now.
+	Signature_max_skew time.Duration `yaml:"signature_max_skew"`
+	// Max_operation_amount caps the amount of a single wallet operation in major units
+	// of its currency, e.g. 1000 allows up to 1000.00 RUB (100000) or 1000 JPY (1000).
+	Max_operation_amount int64 `yaml:"max_operation_amount"`
+	// Otlp_endpoint is the host:port of an OTLP/HTTP collector; empty disables tracing.
+	Otlp_endpoint string `yaml:"otlp_endpoint"`
+	Service_name  string `yaml:"service_name"`
+	// Shutdown_timeout bounds how long in-flight requests are drained on SIGINT/SIGTERM.
+	Shutdown_timeout time.Duration `yaml:"shutdown_timeout"`
+	// Request_timeout is the deadline of every HTTP request; zero disables it.
+	Request_timeout time.Duration `yaml:"request_timeout"`
+	// Operation_timeout is the deadline of every repository call; zero disables it.
+	Operation_timeout time.Duration `yaml:"operation_timeout"`
+	// Statement_timeout is set as the Postgres statement_timeout of every connection.
+	Statement_timeout time.Duration `yaml:"statement_timeout"`
+
+	// Connection pool settings; zero values keep the pgxpool defaults.
+	Db_max_conns           int32         `yaml:"db_max_conns"`
+	Db_min_conns           int32         `yaml:"db_min_conns"`
+	Db_max_conn_lifetime   time.Duration `yaml:"db_max_conn_lifetime"`
+	Db_max_conn_idle_time  time.Duration `yaml:"db_max_conn_idle_time"`
+	Db_health_check_period time.Duration `yaml:"db_health_check_period"`
+
+	// Trusted_proxies lists the IPs and CIDR ranges whose X-Request-ID header is kept.
+	// Set from the environment as a comma-separated list.
+	Trusted_proxies []string `yaml:"trusted_proxies"`
+
+	// Bearer tokens of end users are accepted when any JWT key is set. Issuer and
+	// audience, when set, must match the token claims.
+	Jwt_hs256_secret          string `yaml:"jwt_hs256_secret"`
+	Jwt_rs256_public_key_file string `yaml:"jwt_rs256_public_key_file"`
+	Jwt_jwks_file             string `yaml:"jwt_jwks_file"`
+	Jwt_issuer                string `yaml:"jwt_issuer"`
+	Jwt_audience              string `yaml:"jwt_audience"`
 }
 
+// LoadConfig reads the YAML file at filePath, applies WALLET_* environment
+// overrides and validates the result.
 func LoadConfig(filePath string) (*logger.Config, *ConfigAdr, error) {
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -25,5 +90,194 @@ func LoadConfig(filePath string) (*logger.Config, *ConfigAdr, error) {
 	if err := yaml.Unmarshal(data, cfgAdr); err != nil {
 		return nil, nil, err
 	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := applyEnv(cfgAdr); err != nil {
+		return nil, nil, err
+	}
+	if err := Validate(cfg, cfgAdr); err != nil {
+		return nil, nil, err
+	}
 	return cfg, cfgAdr, nil
 }
+
+// applyEnv overrides every yaml-tagged field of the struct pointed to by v that has
+// a matching WALLET_* environment variable.
+func applyEnv(v any) error {
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag := rt.Field(i).Tag.Get("yaml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := EnvPrefix + strings.ToUpper(tag)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(rv.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, naming each by its yaml key.
+func Validate(cfg *logger.Config, cfgAdr *ConfigAdr) error {
+	var errs []error
+	if !logger.ValidLevel(string(cfg.Level)) {
+		errs = append(errs, fmt.Errorf("level: must be one of debug, info, warn, error, local, stage, prod, got %q", cfg.Level))
+	}
+	if cfg.Gelf_address != "" {
+		addr := strings.TrimPrefix(strings.TrimPrefix(cfg.Gelf_address, "udp://"), "tcp://")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("gelf_address: must be host:port, udp://host:port or tcp://host:port, got %q", cfg.Gelf_address))
+		}
+	}
+	if cfg.Max_size_mb < 0 {
+		errs = append(errs, errors.New("max_size_mb: must not be negative"))
+	}
+	if cfg.Rotate_every < 0 {
+		errs = append(errs, errors.New("rotate_every: must not be negative"))
+	}
+	if cfg.Max_backups < 0 {
+		errs = append(errs, errors.New("max_backups: must not be negative"))
+	}
+	if cfgAdr.Database_url == "" {
+		errs = append(errs, errors.New("database_url: is required"))
+	}
+	if _, _, err := net.SplitHostPort(cfgAdr.APP_ADR); err != nil {
+		errs = append(errs, fmt.Errorf("app_adr: must be host:port, got %q", cfgAdr.APP_ADR))
+	}
+	if cfgAdr.Admin_adr != "" {
+		if _, _, err := net.SplitHostPort(cfgAdr.Admin_adr); err != nil {
+			errs = append(errs, fmt.Errorf("admin_adr: must be host:port, got %q", cfgAdr.Admin_adr))
+		} else if cfgAdr.Admin_adr == cfgAdr.APP_ADR {
+			errs = append(errs, errors.New("admin_adr: must differ from app_adr"))
+		}
+	}
+	if cfgAdr.Idempotency_ttl < 0 {
+		errs = append(errs, errors.New("idempotency_ttl: must not be negative"))
+	}
+	if cfgAdr.Idempotency_lease < 0 {
+		errs = append(errs, errors.New("idempotency_lease: must not be negative"))
+	} else if err := validateIdempotencyLease(cfgAdr); err != nil {
+		errs = append(errs, err)
+	}
+	if cfgAdr.Signature_max_skew < 0 {
+		errs = append(errs, errors.New("signature_max_skew: must not be negative"))
+	} else if cfgAdr.Idempotency_ttl >= 0 {
+		// A signed request with an Idempotency-Key skips the nonce check; the stored key
+		// blocks its replay until the timestamp leaves the skew window on either side.
+		ttl, skew := cfgAdr.Idempotency_ttl, cfgAdr.Signature_max_skew
+		if ttl == 0 {
+			ttl = DefaultIdempotencyTTL
+		}
+		if skew == 0 {
+			skew = DefaultSignatureSkew
+		}
+		if ttl < 2*skew {
+			errs = append(errs, fmt.Errorf("idempotency_ttl: %v must be at least twice signature_max_skew", ttl))
+		}
+	}
+	if cfgAdr.Max_operation_amount < 0 {
+		errs = append(errs, errors.New("max_operation_amount: must not be negative"))
+	}
+	if cfgAdr.Otlp_endpoint != "" {
+		if _, _, err := net.SplitHostPort(cfgAdr.Otlp_endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("otlp_endpoint: must be host:port, got %q", cfgAdr.Otlp_endpoint))
+		}
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"shutdown_timeout", cfgAdr.Shutdown_timeout},
+		{"request_timeout", cfgAdr.Request_timeout},
+		{"operation_timeout", cfgAdr.Operation_timeout},
+		{"statement_timeout", cfgAdr.Statement_timeout},
+		{"db_max_conn_lifetime", cfgAdr.Db_max_conn_lifetime},
+		{"db_max_conn_idle_time", cfgAdr.Db_max_conn_idle_time},
+		{"db_health_check_period", cfgAdr.Db_health_check_period},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", d.name))
+		}
+	}
+	if cfgAdr.Db_max_conns < 0 {
+		errs = append(errs, errors.New("db_max_conns: must not be negative"))
+	}
+	if cfgAdr.Db_min_conns < 0 || (cfgAdr.Db_max_conns > 0 && cfgAdr.Db_min_conns > cfgAdr.Db_max_conns) {
+		errs = append(errs, errors.New("db_min_conns: must be between 0 and db_max_conns"))
+	}
+	if _, err := middleware.ParseTrustedProxies(cfgAdr.Trusted_proxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// validateIdempotencyLease checks that a request finishes before its Idempotency-Key
+// lease runs out; otherwise a retry could reclaim the key and run it twice.
+func validateIdempotencyLease(cfgAdr *ConfigAdr) error {
+	lease := cfgAdr.Idempotency_lease
+	if lease == 0 {
+		lease = DefaultIdempotencyLease
+	}
+	switch {
+	case cfgAdr.Request_timeout > 0:
+		if lease <= cfgAdr.Request_timeout {
+			return fmt.Errorf("idempotency_lease: %v must exceed request_timeout", lease)
+		}
+	case cfgAdr.Operation_timeout > 0:
+		if lease <= cfgAdr.Operation_timeout {
+			return fmt.Errorf("idempotency_lease: %v must exceed operation_timeout when request_timeout is 0", lease)
+		}
+	default:
+		return errors.New("idempotency_lease: request_timeout or operation_timeout must be set to bound requests")
+	}
+	return nil
+}
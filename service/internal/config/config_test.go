@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+path: ""
+level: "local"
+service_name: "service-wallet"
+database_url: "host=db"
+app_adr: ":8080"
+request_timeout: 10s
+idempotency_ttl: 24h
+max_operation_amount: 1000
+`
+
+func writeConfig(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		check         func(t *testing.T, cfgAdr *ConfigAdr)
+		expectedLevel string
+		expectedErr   string
+	}{
+		{
+			name: "YAML Only",
+			check: func(t *testing.T, cfgAdr *ConfigAdr) {
+				assert.Equal(t, "host=db", cfgAdr.Database_url)
+				assert.Equal(t, 24*time.Hour, cfgAdr.Idempotency_ttl)
+			},
+			expectedLevel: "local",
+		},
+		{
+			name: "Env Overrides",
+			env: map[string]string{
+				"WALLET_DATABASE_URL":         "host=localhost",
+				"WALLET_IDEMPOTENCY_TTL":      "1h",
+				"WALLET_MAX_OPERATION_AMOUNT": "50",
+				"WALLET_LEVEL":                "prod",
+				"WALLET_SOURCE":               "true",
+				"WALLET_TRUSTED_PROXIES":      "10.0.0.0/8, 172.16.0.1",
+			},
+			check: func(t *testing.T, cfgAdr *ConfigAdr) {
+				assert.Equal(t, []string{"10.0.0.0/8", "172.16.0.1"}, cfgAdr.Trusted_proxies)
+				assert.Equal(t, "host=localhost", cfgAdr.Database_url)
+				assert.Equal(t, time.Hour, cfgAdr.Idempotency_ttl)
+				assert.Equal(t, int64(50), cfgAdr.Max_operation_amount)
+			},
+			expectedLevel: "prod",
+		},
+		{
+			name:        "Malformed Env Value",
+			env:         map[string]string{"WALLET_SHUTDOWN_TIMEOUT": "soon"},
+			expectedErr: `WALLET_SHUTDOWN_TIMEOUT: time: invalid duration "soon"`,
+		},
+		{
+			name: "Invalid Settings",
+			env: map[string]string{
+				"WALLET_DATABASE_URL":      "",
+				"WALLET_APP_ADR":           "8080",
+				"WALLET_ADMIN_ADR":         "localhost",
+				"WALLET_LEVEL":             "trace",
+				"WALLET_DB_MAX_CONNS":      "10",
+				"WALLET_DB_MIN_CONNS":      "20",
+				"WALLET_REQUEST_TIMEOUT":   "10s",
+				"WALLET_IDEMPOTENCY_LEASE": "5s",
+			},
+			expectedErr: "level: must be one of debug, info, warn, error, local, stage, prod, got \"trace\"\n" +
+				"database_url: is required\n" +
+				"app_adr: must be host:port, got \"8080\"\n" +
+				"admin_adr: must be host:port, got \"localhost\"\n" +
+				"idempotency_lease: 5s must exceed request_timeout\n" +
+				"db_min_conns: must be between 0 and db_max_conns",
+		},
+		{
+			name:        "Request Timeout Exceeds Default Lease",
+			env:         map[string]string{"WALLET_REQUEST_TIMEOUT": "2m"},
+			expectedErr: "idempotency_lease: 1m0s must exceed request_timeout",
+		},
+		{
+			name: "Lease Without Request Timeout",
+			env: map[string]string{
+				"WALLET_REQUEST_TIMEOUT":   "0s",
+				"WALLET_OPERATION_TIMEOUT": "90s",
+			},
+			expectedErr: "idempotency_lease: 1m0s must exceed operation_timeout when request_timeout is 0",
+		},
+		{
+			name: "Idempotency TTL Shorter Than Signature Window",
+			env: map[string]string{
+				"WALLET_IDEMPOTENCY_TTL":    "1m",
+				"WALLET_SIGNATURE_MAX_SKEW": "5m",
+			},
+			expectedErr: "idempotency_ttl: 1m0s must be at least twice signature_max_skew",
+		},
+		{
+			name:        "Unbounded Requests",
+			env:         map[string]string{"WALLET_REQUEST_TIMEOUT": "0s"},
+			expectedErr: "idempotency_lease: request_timeout or operation_timeout must be set to bound requests",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, cfgAdr, err := LoadConfig(writeConfig(t, testConfig))
+
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedLevel, string(cfg.Level))
+			tt.check(t, cfgAdr)
+		})
+	}
+}
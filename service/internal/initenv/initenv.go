@@ -3,15 +3,30 @@ package initenv
 import (
 	"context"
 	"log"
+	"os"
 	"service/internal/config"
 	"service/internal/logger"
 )
 
-func Start(ctx context.Context) (logger.Logger, *config.ConfigAdr, error) {
+const defaultConfigPath = "internal/config/config.yaml"
 
-	cfg, cfgAdr, err := config.LoadConfig("internal/config/config.yaml")
+// ConfigPath picks the config file: the -config flag wins over WALLET_CONFIG,
+// which wins over the default path.
+func ConfigPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if path := os.Getenv(config.EnvPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	return defaultConfigPath
+}
+
+func Start(ctx context.Context, configPath string) (logger.Logger, *config.ConfigAdr, error) {
+
+	cfg, cfgAdr, err := config.LoadConfig(configPath)
 	if err != nil {
-		log.Printf("error load config: %v", err)
+		log.Printf("error load config %s: %v", configPath, err)
 		return nil, nil, err
 	}
 	lg, err := logger.NewLogger(logger.WithCfg(cfg))
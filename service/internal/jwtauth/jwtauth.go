@@ -0,0 +1,150 @@
+// Package jwtauth verifies the bearer tokens end users obtain from the identity
+// provider of the mobile apps.
+package jwtauth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoKeys = errors.New("no JWT verification keys configured")
+
+// Config names the keys tokens may be signed with. Any combination may be set.
+type Config struct {
+	// HS256Secret verifies HS256 tokens.
+	HS256Secret string
+	// RS256PublicKeyFile is a PEM RSA public key that verifies RS256 tokens.
+	RS256PublicKeyFile string
+	// JWKSFile is a JSON Web Key Set whose RSA keys verify RS256 tokens by kid.
+	JWKSFile string
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+// Verifier checks the signature and claims of a token and returns its subject.
+type Verifier struct {
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+	jwks       map[string]*rsa.PublicKey
+	parser     *jwt.Parser
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	v := &Verifier{jwks: make(map[string]*rsa.PublicKey)}
+	if cfg.HS256Secret != "" {
+		v.hmacSecret = []byte(cfg.HS256Secret)
+	}
+	if cfg.RS256PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.RS256PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if v.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.RS256PublicKeyFile, err)
+		}
+	}
+	if cfg.JWKSFile != "" {
+		data, err := os.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		if v.jwks, err = parseJWKS(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.JWKSFile, err)
+		}
+	}
+	if v.hmacSecret == nil && v.rsaKey == nil && len(v.jwks) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Verify returns the subject of a valid token.
+func (v *Verifier) Verify(token string) (string, error) {
+	parsed, err := v.parser.Parse(token, v.key)
+	if err != nil {
+		return "", err
+	}
+	subject, err := parsed.Claims.GetSubject()
+	if err != nil {
+		return "", err
+	}
+	if subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return subject, nil
+}
+
+// key picks the verification key for token. The parser has already rejected
+// algorithms other than RS256 and HS256.
+func (v *Verifier) key(token *jwt.Token) (any, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		if v.hmacSecret == nil {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return v.hmacSecret, nil
+	}
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok := v.jwks[kid]; ok {
+			return key, nil
+		}
+	}
+	if v.rsaKey != nil {
+		return v.rsaKey, nil
+	}
+	return nil, errors.New("no RS256 key matches the token")
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseJWKS returns the RSA signing keys of a JSON Web Key Set by key ID. Keys of
+// other types or uses are skipped.
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid modulus", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid exponent", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
@@ -0,0 +1,174 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestVerifier(t *testing.T) {
+	pemKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwksKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&pemKey.PublicKey)
+	assert.NoError(t, err)
+	pemFile := writeFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec-1"},
+		{
+			"kty": "RSA",
+			"kid": "key-1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(jwksKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(jwksKey.E)).Bytes()),
+		},
+	}})
+	assert.NoError(t, err)
+	jwksFile := writeFile(t, "jwks.json", jwks)
+
+	v, err := NewVerifier(Config{
+		HS256Secret:        "secret",
+		RS256PublicKeyFile: pemFile,
+		JWKSFile:           jwksFile,
+		Issuer:             "https://id.example.com",
+		Audience:           "wallet",
+	})
+	assert.NoError(t, err)
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub": "user-1",
+			"iss": "https://id.example.com",
+			"aud": "wallet",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, val := range overrides {
+			if val == nil {
+				delete(c, k)
+			} else {
+				c[k] = val
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		subject string
+	}{
+		{
+			name:    "HS256",
+			token:   sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims(nil)),
+			subject: "user-1",
+		},
+		{
+			name:    "RS256 PEM Key",
+			token:   sign(t, jwt.SigningMethodRS256, pemKey, "", claims(nil)),
+			subject: "user-1",
+		},
+		{
+			name:    "RS256 JWKS Key",
+			token:   sign(t, jwt.SigningMethodRS256, jwksKey, "key-1", claims(nil)),
+			subject: "user-1",
+		},
+		{
+			name:  "Unknown Key",
+			token: sign(t, jwt.SigningMethodRS256, otherKey, "key-2", claims(nil)),
+		},
+		{
+			name:  "Wrong Secret",
+			token: sign(t, jwt.SigningMethodHS256, []byte("guess"), "", claims(nil)),
+		},
+		{
+			name:  "Algorithm Not Allowed",
+			token: sign(t, jwt.SigningMethodHS512, []byte("secret"), "", claims(nil)),
+		},
+		{
+			name:  "Expired",
+			token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+		},
+		{
+			name:  "No Expiry",
+			token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims(jwt.MapClaims{"exp": nil})),
+		},
+		{
+			name:  "Wrong Issuer",
+			token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+		},
+		{
+			name:  "Wrong Audience",
+			token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims(jwt.MapClaims{"aud": "billing"})),
+		},
+		{
+			name:  "No Subject",
+			token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims(jwt.MapClaims{"sub": nil})),
+		},
+		{
+			name:  "Malformed",
+			token: "not-a-token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := v.Verify(tt.token)
+			if tt.subject == "" {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.subject, subject)
+		})
+	}
+}
+
+func TestNewVerifier_Errors(t *testing.T) {
+	_, err := NewVerifier(Config{})
+	assert.Equal(t, ErrNoKeys, err)
+
+	_, err = NewVerifier(Config{RS256PublicKeyFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+
+	_, err = NewVerifier(Config{RS256PublicKeyFile: writeFile(t, "key.pem", []byte("not a key"))})
+	assert.Error(t, err)
+
+	_, err = NewVerifier(Config{JWKSFile: writeFile(t, "jwks.json", []byte("{"))})
+	assert.Error(t, err)
+
+	// A key set without RSA signing keys verifies nothing.
+	_, err = NewVerifier(Config{JWKSFile: writeFile(t, "jwks.json", []byte(`{"keys":[{"kty":"RSA","kid":"k","use":"enc","n":"AQAB","e":"AQAB"}]}`))})
+	assert.Equal(t, ErrNoKeys, err)
+}
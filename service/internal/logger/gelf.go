@@ -0,0 +1,193 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/sirupsen/logrus"
+)
+
+const gelfVersion = "1.1"
+
+// gelfSender delivers GELF messages to Graylog. *gelf.Writer sends them over UDP.
+type gelfSender interface {
+	WriteMessage(m *gelf.Message) error
+	Close() error
+}
+
+// newGelfSender connects to address, given as udp://host:port, tcp://host:port or
+// host:port for UDP.
+func newGelfSender(address string) (gelfSender, error) {
+	network, addr := "udp", address
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, addr = address[:i], address[i+3:]
+	}
+	switch network {
+	case "udp":
+		return gelf.NewWriter(addr)
+	case "tcp":
+		return newTCPGelfWriter(addr), nil
+	}
+	return nil, fmt.Errorf("unsupported gelf network: %s", network)
+}
+
+const (
+	gelfDialTimeout  = 5 * time.Second
+	gelfWriteTimeout = 5 * time.Second
+	gelfQueueSize    = 1024
+)
+
+// tcpGelfWriter sends uncompressed GELF messages delimited by a null byte, as Graylog's
+// GELF TCP input expects. Messages are queued and sent from a separate goroutine, so
+// a slow or unreachable Graylog never blocks the caller: when the queue is full the
+// message is dropped. The connection is dialed for the first message and redialed
+// after it drops, so the service starts while Graylog is unreachable.
+type tcpGelfWriter struct {
+	addr  string
+	conn  net.Conn
+	queue chan []byte
+	done  chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newTCPGelfWriter(addr string) *tcpGelfWriter {
+	w := &tcpGelfWriter{addr: addr, queue: make(chan []byte, gelfQueueSize), done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+func (w *tcpGelfWriter) WriteMessage(m *gelf.Message) error {
+	buf := new(bytes.Buffer)
+	if err := m.MarshalJSONBuf(buf); err != nil {
+		return err
+	}
+	buf.WriteByte(0)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	select {
+	case w.queue <- buf.Bytes():
+	default:
+	}
+	return nil
+}
+
+// run sends queued messages until Close drains the queue.
+func (w *tcpGelfWriter) run() {
+	defer close(w.done)
+	for frame := range w.queue {
+		if err := w.send(frame); err != nil && w.conn != nil {
+			w.conn.Close()
+			w.conn = nil
+		}
+	}
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+func (w *tcpGelfWriter) send(frame []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.addr, gelfDialTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(gelfWriteTimeout))
+	_, err := w.conn.Write(frame)
+	return err
+}
+
+// Close sends the messages still queued and closes the connection. Messages written
+// after Close are dropped.
+func (w *tcpGelfWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+	return nil
+}
+
+// gelfHook is a logrus hook that forwards every entry to Graylog. Entry fields become
+// GELF additional fields: request_ID is sent as _request_id, service_name as
+// _service_name and the logrus level as the syslog severity in level.
+type gelfHook struct {
+	sender   gelfSender
+	host     string
+	facility string
+}
+
+func newGelfHook(sender gelfSender, facility string) *gelfHook {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &gelfHook{sender: sender, host: host, facility: facility}
+}
+
+func (h *gelfHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *gelfHook) Fire(entry *logrus.Entry) error {
+	extra := make(map[string]interface{}, len(entry.Data)+1)
+	for key, value := range entry.Data {
+		switch key {
+		case "request_ID":
+			key = "request_id"
+		case "client_ID":
+			key = "client_id"
+		case "user_ID":
+			key = "user_id"
+		case "id":
+			// _id is reserved by GELF.
+			key = "field_id"
+		}
+		if err, ok := value.(error); ok {
+			value = fmt.Sprintf("%+v", err)
+		}
+		extra["_"+key] = value
+	}
+	extra["_level_name"] = entry.Level.String()
+
+	return h.sender.WriteMessage(&gelf.Message{
+		Version:  gelfVersion,
+		Host:     h.host,
+		Short:    entry.Message,
+		TimeUnix: float64(entry.Time.UnixNano()) / float64(time.Second),
+		Level:    syslogLevel(entry.Level),
+		Facility: h.facility,
+		Extra:    extra,
+	})
+}
+
+func syslogLevel(level logrus.Level) int32 {
+	switch level {
+	case logrus.PanicLevel:
+		return gelf.LOG_EMERG
+	case logrus.FatalLevel:
+		return gelf.LOG_CRIT
+	case logrus.ErrorLevel:
+		return gelf.LOG_ERR
+	case logrus.WarnLevel:
+		return gelf.LOG_WARNING
+	case logrus.InfoLevel:
+		return gelf.LOG_INFO
+	}
+	return gelf.LOG_DEBUG
+}
@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"service/internal/requestctx"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger_GelfUDP(t *testing.T) {
+	reader, err := gelf.NewReader("127.0.0.1:0")
+	assert.NoError(t, err)
+
+	logBuffer := new(bytes.Buffer)
+	lg, err := NewLogger(WithCfg(&Config{
+		Level:        "local",
+		Service_name: "test_service",
+		Writer:       logBuffer,
+		Gelf_address: "udp://" + reader.Addr(),
+	}))
+	assert.NoError(t, err)
+
+	ctx := requestctx.WithRequestID(context.Background(), "12345")
+	lg.WarnCtx(ctx, "Warning message")
+
+	msg, err := reader.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1", msg.Version)
+	assert.Equal(t, "Warning message", msg.Short)
+	assert.Equal(t, gelf.LOG_WARNING, msg.Level)
+	assert.Equal(t, "test_service", msg.Facility)
+	assert.Equal(t, "12345", msg.Extra["_request_id"])
+	assert.Equal(t, "test_service", msg.Extra["_service_name"])
+	assert.Equal(t, "warning", msg.Extra["_level_name"])
+	// GELF is sent alongside the configured writer.
+	assert.Contains(t, logBuffer.String(), "Warning message")
+}
+
+func TestLogger_GelfTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	lg, err := NewLogger(WithCfg(&Config{
+		Level:        "local",
+		Service_name: "test_service",
+		Gelf_address: "tcp://" + listener.Addr().String(),
+	}))
+	assert.NoError(t, err)
+
+	// The connection is dialed for the first message.
+	lg.ErrorCtx(context.Background(), "Error message")
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	frame, err := bufio.NewReader(conn).ReadBytes(0)
+	assert.NoError(t, err)
+	var msg map[string]any
+	assert.NoError(t, json.Unmarshal(frame[:len(frame)-1], &msg))
+	assert.Equal(t, "Error message", msg["short_message"])
+	assert.Equal(t, float64(gelf.LOG_ERR), msg["level"])
+	assert.Equal(t, "unknown", msg["_request_id"])
+	assert.Equal(t, "test_service", msg["_service_name"])
+}
+
+func TestNewGelfSender_UnsupportedNetwork(t *testing.T) {
+	_, err := newGelfSender("http://graylog:12201")
+	assert.EqualError(t, err, "unsupported gelf network: http")
+}
+
+func TestTCPGelfWriter_DropsWhenQueueIsFull(t *testing.T) {
+	// Nothing drains the queue, as when Graylog stops reading.
+	w := &tcpGelfWriter{queue: make(chan []byte, 1)}
+	msg := &gelf.Message{Version: gelfVersion, Host: "test", Short: "message"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, w.WriteMessage(msg))
+		assert.NoError(t, w.WriteMessage(msg))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WriteMessage blocked on a full queue")
+	}
+	assert.Len(t, w.queue, 1)
+}
+
+func TestLogger_CloseSendsQueuedGelfMessages(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	// Graylog is unreachable at startup.
+	listener.Close()
+
+	lg, err := NewLogger(WithCfg(&Config{
+		Level:        "local",
+		Service_name: "test_service",
+		Gelf_address: "tcp://" + addr,
+	}))
+	assert.NoError(t, err)
+
+	listener, err = net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, _ := bufio.NewReader(conn).ReadBytes(0)
+		received <- frame
+	}()
+
+	lg.ErrorCtx(context.Background(), "Last message")
+	assert.NoError(t, lg.Close())
+	// Entries after Close are dropped.
+	lg.ErrorCtx(context.Background(), "After close")
+
+	select {
+	case frame := <-received:
+		assert.Contains(t, string(frame), "Last message")
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued message was not sent on Close")
+	}
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"service/internal/problem"
+)
+
+const maxLevelBodySize = 1 << 10
+
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler serves the log level of lg: GET returns it and PUT with a body such
+// as {"level":"debug"} changes it until the next restart.
+func LevelHandler(lg Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body levelBody
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLevelBodySize)).Decode(&body); err != nil {
+				problem.Write(w, r, http.StatusBadRequest, problem.InvalidRequest, "request body must be a JSON object")
+				return
+			}
+			previous := lg.Level()
+			if err := lg.SetLevel(body.Level); err != nil {
+				problem.WriteValidation(w, r, []problem.FieldError{{Field: "level", Message: "must be one of debug, info, warn, error, local, stage, prod"}})
+				return
+			}
+			// Logged at Error so that the change shows up whatever the old and new level.
+			lg.ErrorCtx(ctx, fmt.Sprintf("log level changed from %s to %s", previous, lg.Level()))
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			problem.Write(w, r, http.StatusMethodNotAllowed, problem.InvalidRequest, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(levelBody{Level: lg.Level()})
+	}
+}
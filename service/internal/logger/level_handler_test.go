@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/problem"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelHandler(t *testing.T) {
+	testLogger := NewTestLogger()
+	handler := LevelHandler(testLogger.Logger)
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+		expectedLevel  string
+		expectedCode   string
+	}{
+		{name: "Get", method: http.MethodGet, expectedStatus: http.StatusOK, expectedLevel: "debug"},
+		{name: "Set", method: http.MethodPut, body: `{"level":"error"}`, expectedStatus: http.StatusOK, expectedLevel: "error"},
+		{name: "Set Alias", method: http.MethodPut, body: `{"level":"stage"}`, expectedStatus: http.StatusOK, expectedLevel: "info"},
+		{name: "Unknown Level", method: http.MethodPut, body: `{"level":"trace"}`, expectedStatus: http.StatusUnprocessableEntity, expectedCode: problem.ValidationFailed},
+		{name: "Malformed Body", method: http.MethodPut, body: `level=debug`, expectedStatus: http.StatusBadRequest, expectedCode: problem.InvalidRequest},
+		{name: "Wrong Method", method: http.MethodPost, expectedStatus: http.StatusMethodNotAllowed, expectedCode: problem.InvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, "/admin/log-level", strings.NewReader(tt.body)))
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedCode != "" {
+				var body problem.Problem
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, tt.expectedCode, body.Code)
+				return
+			}
+			var body levelBody
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Equal(t, tt.expectedLevel, body.Level)
+		})
+	}
+	assert.Equal(t, "info", testLogger.Logger.Level())
+	assert.Contains(t, testLogger.logBuffer.String(), "log level changed from debug to error")
+}
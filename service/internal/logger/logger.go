@@ -4,33 +4,67 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"service/internal/requestctx"
+	"time"
 
-	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 type logLevel string
 
+// Explicit levels. The environment presets local, stage and prod are kept as
+// aliases for debug, info and error.
 const (
+	debugLogLevel = "debug"
+	infoLogLevel  = "info"
+	warnLogLevel  = "warn"
+	errorLogLevel = "error"
+
 	localLogLevel = "local"
 	stageLogLevel = "stage"
 	prodLogLevel  = "prod"
 )
 
+var logLevels = map[string]logrus.Level{
+	debugLogLevel: logrus.DebugLevel,
+	infoLogLevel:  logrus.InfoLevel,
+	warnLogLevel:  logrus.WarnLevel,
+	errorLogLevel: logrus.ErrorLevel,
+	localLogLevel: logrus.DebugLevel,
+	stageLogLevel: logrus.InfoLevel,
+	prodLogLevel:  logrus.ErrorLevel,
+}
+
+// ValidLevel reports whether level is a level or preset name the logger accepts.
+func ValidLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
+
+const badKey = "!BADKEY"
+
 type Logger interface {
 	DebugCtx(ctx context.Context, msg string)
 	InfoCtx(ctx context.Context, msg string)
 	WarnCtx(ctx context.Context, msg string)
 	ErrorCtx(ctx context.Context, msg string)
 	FatalCtx(ctx context.Context, msg string, err error)
+	With(keysAndValues ...any) Logger
+	// SetLevel changes the level of this logger and every logger derived from it.
+	SetLevel(level string) error
+	// Level returns the current level as one of debug, info, warn or error.
+	Level() string
+	// Close sends the entries still queued for Graylog. It also runs before FatalCtx
+	// exits the process.
+	Close() error
 }
 
 type logger struct {
 	log        *logrus.Logger
 	cfg        *Config
-	gelfWriter *gelf.Writer
+	gelfWriter gelfSender
+	fields     logrus.Fields
 }
 
 type Config struct {
@@ -39,6 +73,19 @@ type Config struct {
 	Source       bool      `yaml:"source"`
 	Service_name string    `yaml:"service_name"`
 	Writer       io.Writer `yaml:"writer"`
+	// Gelf_address sends entries to Graylog as well, e.g. udp://graylog:12201 or
+	// tcp://graylog:12201. With neither Path nor Writer set, Graylog is the only output.
+	Gelf_address string `yaml:"gelf_address"`
+
+	// Rotation of the file at Path; zero values disable the respective limit.
+	// Max_size_mb and Rotate_every start a new file, Max_backups caps the rotated
+	// files that are kept and Compress gzips them.
+	Max_size_mb  int           `yaml:"max_size_mb"`
+	Rotate_every time.Duration `yaml:"rotate_every"`
+	Max_backups  int           `yaml:"max_backups"`
+	Compress     bool          `yaml:"compress"`
+	// Reopen_on_sighup reopens Path on SIGHUP, for rotation by an external logrotate.
+	Reopen_on_sighup bool `yaml:"reopen_on_sighup"`
 }
 
 func NewLogger(options ...Option) (Logger, error) {
@@ -59,87 +106,116 @@ func NewLogger(options ...Option) (Logger, error) {
 }
 
 func (l *logger) setLogLevel() error {
-	switch l.cfg.Level {
-	case localLogLevel:
-		l.log.SetLevel(logrus.DebugLevel)
-	case stageLogLevel:
-		l.log.SetLevel(logrus.InfoLevel)
-	case prodLogLevel:
-		l.log.SetLevel(logrus.ErrorLevel)
-	default:
-		return fmt.Errorf("неизвестный уровень логирования: %s", l.cfg.Level)
+	return l.SetLevel(string(l.cfg.Level))
+}
+
+func (l *logger) SetLevel(level string) error {
+	lvl, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("неизвестный уровень логирования: %s", level)
 	}
+	l.log.SetLevel(lvl)
 	return nil
 }
 
+func (l *logger) Level() string {
+	lvl := l.log.GetLevel()
+	if lvl == logrus.WarnLevel {
+		return warnLogLevel
+	}
+	return lvl.String()
+}
+
 func (l *logger) setupOutput() error {
 	if l.cfg.Path != "" {
-		file, err := os.OpenFile(l.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := newRotatingWriter(l.cfg)
 		if err != nil {
 			return err
 		}
+		if l.cfg.Reopen_on_sighup {
+			file.reopenOnSIGHUP()
+		}
 		l.log.SetOutput(file)
+	} else if l.cfg.Writer == nil && l.cfg.Gelf_address != "" {
+		l.log.SetOutput(io.Discard)
 	} else {
 		l.log.SetOutput(l.cfg.Writer)
 	}
+
+	if l.cfg.Gelf_address != "" {
+		sender, err := newGelfSender(l.cfg.Gelf_address)
+		if err != nil {
+			return err
+		}
+		l.gelfWriter = sender
+		l.log.AddHook(newGelfHook(sender, l.cfg.Service_name))
+		// Fatal exits through os.Exit, which skips deferred calls.
+		logrus.RegisterExitHandler(func() { l.Close() })
+	}
 	return nil
 }
 
-func (l *logger) DebugCtx(ctx context.Context, msg string) {
-	requestID, ok := ctx.Value("requestID").(string)
-	if !ok {
-		requestID = "unknown"
+func (l *logger) Close() error {
+	if l.gelfWriter == nil {
+		return nil
 	}
-	l.log.WithFields(logrus.Fields{
-		"request_ID":   requestID,
-		"service_name": l.cfg.Service_name,
-	}).Debug(msg)
-
+	return l.gelfWriter.Close()
 }
 
-func (l *logger) InfoCtx(ctx context.Context, msg string) {
+// With returns a logger that adds the given key-value pairs as structured fields to
+// every entry, e.g. lg.With("walletId", id, "amount", amount).InfoCtx(ctx, "deposited").
+// A trailing key without a value is logged under !BADKEY.
+func (l *logger) With(keysAndValues ...any) Logger {
+	fields := make(logrus.Fields, len(l.fields)+len(keysAndValues)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			fields[badKey] = keysAndValues[i]
+			break
+		}
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	child := *l
+	child.fields = fields
+	return &child
+}
 
-	requestID, ok := ctx.Value("requestID").(string)
+func (l *logger) entry(ctx context.Context) *logrus.Entry {
+	requestID, ok := requestctx.RequestIDFromContext(ctx)
 	if !ok {
 		requestID = "unknown"
 	}
-	l.log.WithFields(logrus.Fields{
+	entry := l.log.WithFields(l.fields).WithFields(logrus.Fields{
 		"request_ID":   requestID,
 		"service_name": l.cfg.Service_name,
-	}).Info(msg)
+	})
+	if clientID, ok := requestctx.ClientIDFromContext(ctx); ok {
+		entry = entry.WithField("client_ID", clientID)
+	}
+	if userID, ok := requestctx.UserIDFromContext(ctx); ok {
+		entry = entry.WithField("user_ID", userID)
+	}
+	return entry
+}
 
+func (l *logger) DebugCtx(ctx context.Context, msg string) {
+	l.entry(ctx).Debug(msg)
 }
-func (l *logger) WarnCtx(ctx context.Context, msg string) {
-	requestID, ok := ctx.Value("requestID").(string)
-	if !ok {
-		requestID = "unknown"
-	}
-	l.log.WithFields(logrus.Fields{
-		"request_ID":   requestID,
-		"service_name": l.cfg.Service_name,
-	}).Warn(msg)
 
+func (l *logger) InfoCtx(ctx context.Context, msg string) {
+	l.entry(ctx).Info(msg)
 }
 
-func (l *logger) ErrorCtx(ctx context.Context, msg string) {
+func (l *logger) WarnCtx(ctx context.Context, msg string) {
+	l.entry(ctx).Warn(msg)
+}
 
-	requestID, ok := ctx.Value("requestID").(string)
-	if !ok {
-		requestID = "unknown"
-	}
-	l.log.WithFields(logrus.Fields{
-		"request_ID":   requestID,
-		"service_name": l.cfg.Service_name,
-	}).Error(msg)
+func (l *logger) ErrorCtx(ctx context.Context, msg string) {
+	l.entry(ctx).Error(msg)
 }
+
 func (l *logger) FatalCtx(ctx context.Context, msg string, err error) {
-	requestID, ok := ctx.Value("requestID").(string)
-	if !ok {
-		requestID = "unknown"
-	}
-	l.log.WithFields(logrus.Fields{
-		"request_ID":   requestID,
-		"service_name": l.cfg.Service_name,
-		"stack_trace":  errors.WithStack(err),
-	}).Fatal(msg)
+	l.entry(ctx).WithField("stack_trace", errors.WithStack(err)).Fatal(msg)
 }
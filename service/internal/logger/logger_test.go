@@ -3,6 +3,8 @@ package logger
 import (
 	"bytes"
 	"context"
+	"service/internal/requestctx"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,7 +38,7 @@ func NewTestLogger() *TestLogger {
 
 func TestLogger_DebugCtx(t *testing.T) {
 	testLogger := NewTestLogger()
-	ctx := context.WithValue(context.Background(), "requestID", "12345")
+	ctx := requestctx.WithRequestID(context.Background(), "12345")
 
 	testLogger.Logger.DebugCtx(ctx, "Debug message")
 
@@ -47,7 +49,7 @@ func TestLogger_DebugCtx(t *testing.T) {
 
 func TestLogger_InfoCtx(t *testing.T) {
 	testLogger := NewTestLogger()
-	ctx := context.WithValue(context.Background(), "requestID", "67890")
+	ctx := requestctx.WithRequestID(context.Background(), "67890")
 
 	testLogger.Logger.InfoCtx(ctx, "Info message")
 
@@ -58,7 +60,7 @@ func TestLogger_InfoCtx(t *testing.T) {
 
 func TestLogger_WarnCtx(t *testing.T) {
 	testLogger := NewTestLogger()
-	ctx := context.WithValue(context.Background(), "requestID", "54321")
+	ctx := requestctx.WithRequestID(context.Background(), "54321")
 
 	testLogger.Logger.WarnCtx(ctx, "Warning message")
 
@@ -69,7 +71,7 @@ func TestLogger_WarnCtx(t *testing.T) {
 
 func TestLogger_ErrorCtx(t *testing.T) {
 	testLogger := NewTestLogger()
-	ctx := context.WithValue(context.Background(), "requestID", "98765")
+	ctx := requestctx.WithRequestID(context.Background(), "98765")
 
 	testLogger.Logger.ErrorCtx(ctx, "Error message")
 
@@ -77,3 +79,66 @@ func TestLogger_ErrorCtx(t *testing.T) {
 	assert.Contains(t, testLogger.logBuffer.String(), "request_ID=98765")
 	assert.Contains(t, testLogger.logBuffer.String(), "service_name=test_service")
 }
+
+func TestLogger_With(t *testing.T) {
+	testLogger := NewTestLogger()
+	ctx := requestctx.WithRequestID(context.Background(), "12345")
+
+	walletLogger := testLogger.Logger.With("walletId", "abc", "amount", int64(100))
+	walletLogger.With("error", "insufficient funds").ErrorCtx(ctx, "wallet operation failed")
+	testLogger.Logger.InfoCtx(ctx, "Info message")
+	walletLogger.With("dangling").InfoCtx(ctx, "odd fields")
+
+	lines := strings.Split(strings.TrimSpace(testLogger.logBuffer.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "walletId=abc")
+	assert.Contains(t, lines[0], "amount=100")
+	assert.Contains(t, lines[0], `error="insufficient funds"`)
+	assert.Contains(t, lines[0], "request_ID=12345")
+	// Fields do not leak into the parent logger.
+	assert.NotContains(t, lines[1], "walletId")
+	assert.Contains(t, lines[2], "!BADKEY=dangling")
+	assert.NotContains(t, lines[2], "error=")
+}
+
+func TestLogger_Levels(t *testing.T) {
+	tests := []struct {
+		level         string
+		expectedLevel string
+		expectedErr   bool
+	}{
+		{level: "debug", expectedLevel: "debug"},
+		{level: "warn", expectedLevel: "warn"},
+		{level: "local", expectedLevel: "debug"},
+		{level: "stage", expectedLevel: "info"},
+		{level: "prod", expectedLevel: "error"},
+		{level: "verbose", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			lg, err := NewLogger(WithCfg(&Config{Level: logLevel(tt.level), Writer: new(bytes.Buffer)}))
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedLevel, lg.Level())
+		})
+	}
+}
+
+func TestLogger_SetLevel(t *testing.T) {
+	testLogger := NewTestLogger()
+	walletLogger := testLogger.Logger.With("walletId", "abc")
+	ctx := context.Background()
+
+	assert.NoError(t, testLogger.Logger.SetLevel("warn"))
+	walletLogger.InfoCtx(ctx, "dropped")
+	walletLogger.WarnCtx(ctx, "kept")
+	assert.Error(t, testLogger.Logger.SetLevel("verbose"))
+
+	assert.Equal(t, "warn", walletLogger.Level())
+	assert.NotContains(t, testLogger.logBuffer.String(), "dropped")
+	assert.Contains(t, testLogger.logBuffer.String(), "kept")
+}
@@ -0,0 +1,208 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405.000"
+
+// rotatingWriter is the file output of the logger. It moves the file aside once it
+// reaches maxSize bytes or has been open for every, optionally gzips the moved file
+// and keeps at most maxBackups of them. Zero values disable the respective limit.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	every      time.Duration
+	maxBackups int
+	compress   bool
+	now        func() time.Time
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// post tracks compression and pruning of rotated files, which run in the
+	// background one rotation at a time.
+	post   sync.WaitGroup
+	postMu sync.Mutex
+}
+
+func newRotatingWriter(cfg *Config) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.Max_size_mb) << 20,
+		every:      cfg.Rotate_every,
+		maxBackups: cfg.Max_backups,
+		compress:   cfg.Compress,
+		now:        time.Now,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.now()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A failed rotation is reported, but the entry is still written to the current
+	// file and the next write tries again.
+	var rotateErr error
+	if w.shouldRotate(len(p)) {
+		rotateErr = w.rotate()
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (w *rotatingWriter) shouldRotate(next int) bool {
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(next) > w.maxSize {
+		return true
+	}
+	return w.every > 0 && w.now().Sub(w.openedAt) >= w.every
+}
+
+// rotate moves the file aside and opens a new one at path. The old file stays open
+// until the new one is, so a failure leaves the writer logging to the original path.
+func (w *rotatingWriter) rotate() error {
+	backup := w.path + "." + w.now().Format(backupTimeFormat)
+	if err := os.Rename(w.path, backup); err != nil {
+		return err
+	}
+	old := w.file
+	if err := w.open(); err != nil {
+		os.Rename(backup, w.path)
+		return err
+	}
+	old.Close()
+	w.post.Add(1)
+	go func() {
+		defer w.post.Done()
+		w.postMu.Lock()
+		defer w.postMu.Unlock()
+		if w.compress {
+			compressFile(backup)
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+// Reopen reopens the file, for use after an external tool such as logrotate has
+// moved it. The old file is kept when the new one cannot be opened.
+func (w *rotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	old := w.file
+	if err := w.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// reopenOnSIGHUP calls Reopen whenever the process receives SIGHUP.
+func (w *rotatingWriter) reopenOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			w.Reopen()
+		}
+	}()
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.post.Wait()
+	return w.file.Close()
+}
+
+// prune removes the oldest rotated files beyond maxBackups. Backup names end in a
+// sortable timestamp, so name order is age order.
+func (w *rotatingWriter) prune() {
+	if w.maxBackups <= 0 {
+		return
+	}
+	names, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, name := range names {
+		if w.isBackup(name) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-w.maxBackups; i++ {
+		os.Remove(backups[i])
+	}
+}
+
+// isBackup reports whether name was written by rotate: path, a dot and a timestamp,
+// optionally followed by .gz. Other files next to the log are left alone.
+func (w *rotatingWriter) isBackup(name string) bool {
+	suffix := strings.TrimSuffix(strings.TrimPrefix(name, w.path+"."), ".gz")
+	_, err := time.Parse(backupTimeFormat, suffix)
+	return err == nil
+}
+
+// compressFile replaces name with name.gz.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock advances by one second on every reading so backup names are unique.
+func fakeClock(start time.Time) func() time.Time {
+	now := start
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func backups(t *testing.T, path string) []string {
+	names, err := filepath.Glob(path + ".*")
+	assert.NoError(t, err)
+	sort.Strings(names)
+	return names
+}
+
+func TestRotatingWriter_Size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w := &rotatingWriter{path: path, maxSize: 10, maxBackups: 2, now: fakeClock(time.Now())}
+	assert.NoError(t, w.open())
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	current, _ := os.ReadFile(path)
+	assert.Equal(t, "fourth\n", string(current))
+	kept := backups(t, path)
+	assert.Len(t, kept, 2)
+	newest, _ := os.ReadFile(kept[1])
+	assert.Equal(t, "third\n", string(newest))
+}
+
+func TestRotatingWriter_TimeAndCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w := &rotatingWriter{path: path, every: time.Minute, compress: true, now: fakeClock(time.Now())}
+	assert.NoError(t, w.open())
+
+	_, err := w.Write([]byte("old entry\n"))
+	assert.NoError(t, err)
+	w.openedAt = w.openedAt.Add(-time.Hour)
+	_, err = w.Write([]byte("new entry\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	kept := backups(t, path)
+	assert.Len(t, kept, 1)
+	assert.Equal(t, ".gz", filepath.Ext(kept[0]))
+	file, err := os.Open(kept[0])
+	assert.NoError(t, err)
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(zr)
+	assert.Equal(t, "old entry\n", string(data))
+}
+
+func TestRotatingWriter_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := newRotatingWriter(&Config{Path: path})
+	assert.NoError(t, err)
+
+	w.Write([]byte("before\n"))
+	// An external logrotate moves the file away and signals the service.
+	assert.NoError(t, os.Rename(path, path+".1"))
+	assert.NoError(t, w.Reopen())
+	w.Write([]byte("after\n"))
+	assert.NoError(t, w.Close())
+
+	moved, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	assert.Equal(t, "before\n", string(moved))
+	assert.Equal(t, "after\n", string(current))
+}
+
+func TestRotatingWriter_FailedRotationKeepsLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	now := time.Now()
+	w := &rotatingWriter{path: path, maxSize: 10, now: func() time.Time { return now }}
+	assert.NoError(t, w.open())
+
+	// A non-empty directory at the backup name makes the rename fail.
+	blocker := path + "." + now.Format(backupTimeFormat)
+	assert.NoError(t, os.MkdirAll(filepath.Join(blocker, "x"), 0755))
+
+	_, err := w.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("second\n"))
+	assert.Error(t, err)
+	_, err = w.Write([]byte("third\n"))
+	assert.Error(t, err)
+
+	assert.NoError(t, os.RemoveAll(blocker))
+	_, err = w.Write([]byte("fourth\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	moved, _ := os.ReadFile(blocker)
+	current, _ := os.ReadFile(path)
+	assert.Equal(t, "first\nsecond\nthird\n", string(moved))
+	assert.Equal(t, "fourth\n", string(current))
+}
+
+func TestRotatingWriter_PruneKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	for _, name := range []string{path + ".gz", path + ".lock"} {
+		assert.NoError(t, os.WriteFile(name, []byte("keep"), 0644))
+	}
+	w := &rotatingWriter{path: path, maxSize: 10, maxBackups: 1, now: fakeClock(time.Now())}
+	assert.NoError(t, w.open())
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	assert.FileExists(t, path+".gz")
+	assert.FileExists(t, path+".lock")
+	assert.Len(t, backups(t, path), 3)
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Operation outcomes used as the outcome label of OperationsTotal.
+const (
+	OutcomeSuccess           = "success"
+	OutcomeNotFound          = "not_found"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeRejected          = "rejected"
+	OutcomeDBError           = "db_error"
+	OutcomeTimeout           = "timeout"
+)
+
+var (
+	RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wallet_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status code.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"route", "method", "status"})
+
+	OperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_operations_total",
+		Help: "Wallet operations by operation type and outcome.",
+	}, []string{"operation_type", "outcome"})
+
+	AmountMovedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_amount_moved_total",
+		Help: "Sum of successfully applied amounts in minor units by operation type.",
+	}, []string{"operation_type"})
+)
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveOperation counts one wallet operation and, when it succeeded, the amount it moved.
+func ObserveOperation(operationType, outcome string, amount int64) {
+	OperationsTotal.WithLabelValues(operationType, outcome).Inc()
+	if outcome == OutcomeSuccess {
+		AmountMovedTotal.WithLabelValues(operationType).Add(float64(amount))
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware records the count and latency of every request. Requests are labelled
+// with the chi route pattern rather than the path, so wallet IDs do not end up in labels.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := strconv.Itoa(rec.status)
+		RequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+		RequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// poolCollector reads pgxpool statistics on every scrape.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+	constructing *prometheus.Desc
+	waited       *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+// NewPoolCollector exposes the connection pool stats. pgxpool does not report how
+// many acquires are waiting right now, so waits are exposed as counters instead:
+// wallet_db_pool_empty_acquire_total grows whenever a caller had to wait for a connection.
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	return &poolCollector{
+		pool:         pool,
+		acquired:     prometheus.NewDesc("wallet_db_pool_acquired_conns", "Connections currently in use.", nil, nil),
+		idle:         prometheus.NewDesc("wallet_db_pool_idle_conns", "Idle connections in the pool.", nil, nil),
+		total:        prometheus.NewDesc("wallet_db_pool_total_conns", "Open connections in the pool.", nil, nil),
+		max:          prometheus.NewDesc("wallet_db_pool_max_conns", "Maximum size of the pool.", nil, nil),
+		constructing: prometheus.NewDesc("wallet_db_pool_constructing_conns", "Connections being opened.", nil, nil),
+		waited:       prometheus.NewDesc("wallet_db_pool_empty_acquire_total", "Acquires that waited because the pool was empty.", nil, nil),
+		waitDuration: prometheus.NewDesc("wallet_db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections.", nil, nil),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.constructing
+	ch <- c.waited
+	ch <- c.waitDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructing, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.waited, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// RegisterPool exposes the stats of pool together with the other metrics.
+func RegisterPool(pool *pgxpool.Pool) {
+	prometheus.MustRegister(NewPoolCollector(pool))
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/v1/balance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	tests := []struct {
+		name  string
+		path  string
+		route string
+	}{
+		{name: "Route Pattern", path: "/api/v1/balance/123", route: "/api/v1/balance/{id}"},
+		{name: "Unmatched Route", path: "/unknown", route: "unmatched"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(RequestsTotal.WithLabelValues(tt.route, http.MethodGet, "404"))
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, before+1, testutil.ToFloat64(RequestsTotal.WithLabelValues(tt.route, http.MethodGet, "404")))
+		})
+	}
+}
+
+func TestObserveOperation(t *testing.T) {
+	successBefore := testutil.ToFloat64(OperationsTotal.WithLabelValues("DEPOSIT", OutcomeSuccess))
+	amountBefore := testutil.ToFloat64(AmountMovedTotal.WithLabelValues("DEPOSIT"))
+
+	ObserveOperation("DEPOSIT", OutcomeSuccess, 150)
+	ObserveOperation("DEPOSIT", OutcomeNotFound, 500)
+
+	assert.Equal(t, successBefore+1, testutil.ToFloat64(OperationsTotal.WithLabelValues("DEPOSIT", OutcomeSuccess)))
+	assert.Equal(t, amountBefore+150, testutil.ToFloat64(AmountMovedTotal.WithLabelValues("DEPOSIT")))
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"service/internal/logger"
+	"service/internal/problem"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// responseRecorder remembers the status and the number of body bytes written.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// AccessLog writes one line per request with its method, route pattern, status,
+// response size and duration. The request ID is added by lg from the context, so
+// AccessLog must run after RequestID. Server errors are logged at error level.
+func AccessLog(lg logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			entry := lg.With(
+				"method", r.Method,
+				"route", route,
+				"status", rec.status,
+				"bytes", rec.bytes,
+				"durationMs", float64(time.Since(start).Microseconds())/1000,
+			)
+			msg := fmt.Sprintf("%s %s %d", r.Method, route, rec.status)
+			if rec.status >= http.StatusInternalServerError {
+				entry.ErrorCtx(r.Context(), msg)
+				return
+			}
+			entry.InfoCtx(r.Context(), msg)
+		})
+	}
+}
+
+// Recover turns a panic in a handler into a 500 problem response and logs it with
+// the stack trace, instead of dropping the connection. http.ErrAbortHandler is
+// re-raised, since it is the way to abort a response on purpose.
+func Recover(lg logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				lg.With("panic", fmt.Sprint(rec), "stack_trace", string(debug.Stack())).ErrorCtx(r.Context(), "panic recovered")
+				problem.Write(w, r, http.StatusInternalServerError, problem.InternalError, "internal server error")
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/logger"
+	"service/internal/problem"
+	"service/internal/requestctx"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(t *testing.T) (logger.Logger, *bytes.Buffer) {
+	buf := new(bytes.Buffer)
+	lg, err := logger.NewLogger(logger.WithCfg(&logger.Config{Level: "debug", Service_name: "test_service", Writer: buf}))
+	assert.NoError(t, err)
+	return lg, buf
+}
+
+func TestAccessLogAndRecover(t *testing.T) {
+	lg, buf := newTestLogger(t)
+	proxies, _ := ParseTrustedProxies([]string{"192.0.2.0/24"})
+
+	r := chi.NewRouter()
+	r.Use(RequestID(proxies))
+	r.Use(AccessLog(lg))
+	r.Use(Recover(lg))
+	r.Get("/api/v1/balance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	r.Post("/api/v1/wallet", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	t.Run("Access Log", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/balance/123", nil)
+		req.Header.Set(requestctx.Header, "req-1")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		line := buf.String()
+		assert.Contains(t, line, "level=info")
+		assert.Contains(t, line, "method=GET")
+		assert.Contains(t, line, "route=\"/api/v1/balance/{id}\"")
+		assert.Contains(t, line, "status=200")
+		assert.Contains(t, line, "bytes=5")
+		assert.Contains(t, line, "durationMs=")
+		assert.Contains(t, line, "request_ID=req-1")
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/wallet", nil))
+
+		res := w.Result()
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+		var body problem.Problem
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		assert.Equal(t, problem.InternalError, body.Code)
+		assert.Equal(t, res.Header.Get(requestctx.Header), body.RequestID)
+
+		logs := buf.String()
+		assert.Contains(t, logs, "panic recovered")
+		assert.Contains(t, logs, "panic=boom")
+		assert.Contains(t, logs, "stack_trace=")
+		assert.Contains(t, logs, "status=500")
+		assert.Contains(t, logs, "level=error")
+	})
+
+	t.Run("Abort Handler", func(t *testing.T) {
+		handler := Recover(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
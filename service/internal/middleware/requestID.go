@@ -1,26 +1,86 @@
 package middleware
 
 import (
-	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"service/internal/requestctx"
 
 	guid "github.com/satori/go.uuid"
 )
 
-const RequestIDContextKey = "requestID"
+// maxRequestIDLength matches wallet_transactions.request_id, VARCHAR(64).
+const maxRequestIDLength = 64
 
-func ContextRequestMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+// RequestID assigns every request an ID, stores it with requestctx.WithRequestID
+// and echoes it in the X-Request-ID response header. An incoming X-Request-ID is
+// kept only when the request comes from one of trustedProxies and the ID is valid;
+// otherwise a new UUID is generated.
+func RequestID(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqID := r.Header.Get(requestctx.Header)
+			if !validRequestID(reqID) || !trusted(r.RemoteAddr, trustedProxies) {
+				reqID = guid.NewV4().String()
+			}
 
-		reqID := r.Header.Get("X-Request-ID")
-		if reqID == "" {
-			uuid := guid.NewV4()
-			reqID = uuid.String()
+			w.Header().Set(requestctx.Header, reqID)
+			next.ServeHTTP(w, r.WithContext(requestctx.WithRequestID(r.Context(), reqID)))
+		})
+	}
+}
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges such as 10.0.0.0/8.
+func ParseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
 		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
 
-		ctx = context.WithValue(ctx, RequestIDContextKey, reqID)
+func trusted(remoteAddr string, proxies []netip.Prefix) bool {
+	if len(proxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// validRequestID accepts 1 to 64 characters of letters, digits and . _ : -
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"service/internal/requestctx"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		trusted    []netip.Prefix
+		expectedID string
+	}{
+		{name: "Trusted Proxy", remoteAddr: "10.1.2.3:5000", header: "req-1", trusted: proxies, expectedID: "req-1"},
+		{name: "Trusted Single Address", remoteAddr: "192.0.2.7:5000", header: "trace:abc_1.2", trusted: proxies, expectedID: "trace:abc_1.2"},
+		{name: "Untrusted Client", remoteAddr: "203.0.113.9:5000", header: "req-1", trusted: proxies},
+		{name: "No Trusted Proxies", remoteAddr: "10.1.2.3:5000", header: "req-1"},
+		{name: "Invalid Charset", remoteAddr: "10.1.2.3:5000", header: "req 1<script>", trusted: proxies},
+		{name: "Longest", remoteAddr: "10.1.2.3:5000", header: strings.Repeat("a", 64), trusted: proxies, expectedID: strings.Repeat("a", 64)},
+		{name: "Too Long", remoteAddr: "10.1.2.3:5000", header: strings.Repeat("a", 65), trusted: proxies},
+		{name: "Missing", remoteAddr: "10.1.2.3:5000", trusted: proxies},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(tt.trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = requestctx.RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set(requestctx.Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if tt.expectedID != "" {
+				assert.Equal(t, tt.expectedID, seen)
+			} else {
+				assert.NotEqual(t, tt.header, seen)
+				assert.Len(t, seen, 36)
+			}
+			assert.Equal(t, seen, w.Header().Get(requestctx.Header))
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	_, err := ParseTrustedProxies([]string{"10.0.0.0/8", "proxy.local"})
+	assert.EqualError(t, err, `invalid trusted proxy "proxy.local"`)
+}
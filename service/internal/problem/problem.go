@@ -0,0 +1,84 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"service/internal/requestctx"
+)
+
+const ContentType = "application/problem+json"
+
+// Stable error codes returned in the "code" member of every problem response.
+const (
+	InvalidRequest        = "INVALID_REQUEST"
+	ValidationFailed      = "VALIDATION_FAILED"
+	RequestTooLarge       = "REQUEST_TOO_LARGE"
+	InvalidOperation      = "INVALID_OPERATION"
+	WalletNotFound        = "WALLET_NOT_FOUND"
+	WalletClosed          = "WALLET_CLOSED"
+	WalletNotEmpty        = "WALLET_NOT_EMPTY"
+	InsufficientFunds     = "INSUFFICIENT_FUNDS"
+	CurrencyMismatch      = "CURRENCY_MISMATCH"
+	ExternalRefExists     = "EXTERNAL_REF_EXISTS"
+	IdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	IdempotencyInProgress = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	HoldNotFound          = "HOLD_NOT_FOUND"
+	HoldNotActive         = "HOLD_NOT_ACTIVE"
+	CaptureExceedsHold    = "CAPTURE_EXCEEDS_HOLD"
+	Unauthorized          = "UNAUTHORIZED"
+	Forbidden             = "FORBIDDEN"
+	InvalidSignature      = "INVALID_SIGNATURE"
+	RequestReplayed       = "REQUEST_REPLAYED"
+	InternalError         = "INTERNAL_ERROR"
+	ServiceUnavailable    = "SERVICE_UNAVAILABLE"
+)
+
+// Problem is an RFC 7807 problem details body extended with a code and the request ID.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func New(r *http.Request, status int, code, detail string) *Problem {
+	requestID, _ := requestctx.RequestIDFromContext(r.Context())
+	return &Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestID,
+	}
+}
+
+func (p *Problem) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Write replies to r with a problem response.
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	New(r, status, code, detail).Write(w)
+}
+
+// WriteValidation replies with a single 422 response listing every invalid field.
+func WriteValidation(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	p := New(r, http.StatusUnprocessableEntity, ValidationFailed, "request validation failed")
+	p.Errors = errs
+	p.Write(w)
+}
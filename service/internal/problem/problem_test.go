@@ -0,0 +1,35 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/requestctx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/balance/123", nil)
+	req = req.WithContext(requestctx.WithRequestID(req.Context(), "req-1"))
+	w := httptest.NewRecorder()
+
+	Write(w, req, http.StatusNotFound, WalletNotFound, "walletid not found")
+
+	res := w.Result()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, ContentType, res.Header.Get("Content-Type"))
+
+	var body Problem
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "walletid not found",
+		Instance:  "/api/v1/balance/123",
+		Code:      WalletNotFound,
+		RequestID: "req-1",
+	}, body)
+}
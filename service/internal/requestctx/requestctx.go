@@ -0,0 +1,47 @@
+// Package requestctx carries the request ID through a context. It has no
+// dependencies so that the logger, middleware and problem packages can share it.
+package requestctx
+
+import "context"
+
+// Header is the HTTP header the request ID is read from and echoed in.
+const Header = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set by WithRequestID.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+type clientIDKey struct{}
+
+// WithClientID returns a copy of ctx that carries the ID of the authenticated API client.
+func WithClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDKey{}, id)
+}
+
+// ClientIDFromContext returns the client ID set by WithClientID.
+func ClientIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(clientIDKey{}).(string)
+	return id, ok
+}
+
+type userIDKey struct{}
+
+// WithUserID returns a copy of ctx that carries the subject of the end user's bearer token.
+func WithUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, id)
+}
+
+// UserIDFromContext returns the user ID set by WithUserID.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userIDKey{}).(string)
+	return id, ok
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"service/internal/middleware"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := Setup(context.Background(), "", "service-wallet")
+	assert.NoError(t, err)
+
+	var handlerSpan trace.SpanContext
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}))
+	r.Use(Middleware)
+	r.Get("/api/v1/balance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/balance/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-ID", "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /api/v1/balance/{id}", span.Name())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+		assert.Contains(t, span.Attributes(), RequestIDKey.String("req-1"))
+		assert.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+		assert.Equal(t, span.SpanContext().SpanID(), handlerSpan.SpanID())
+	}
+}
@@ -0,0 +1,253 @@
+package wallet
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"service/internal/requestctx"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// API client scopes. SCOPE_ADMIN grants every other scope as well.
+const (
+	SCOPE_READ_BALANCE string = "read-balance"
+	SCOPE_DEPOSIT      string = "deposit"
+	SCOPE_WITHDRAW     string = "withdraw"
+	SCOPE_ADMIN        string = "admin"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	bearerPrefix = "Bearer "
+)
+
+// userScopes are granted to end users authenticated by a bearer token. Reads and
+// debits are further limited to the wallets the user owns.
+var userScopes = []string{SCOPE_READ_BALANCE, SCOPE_DEPOSIT, SCOPE_WITHDRAW}
+
+var (
+	errUnauthorized = errors.New("missing or invalid credentials")
+	errForbidden    = errors.New("API client is not allowed to perform this operation")
+)
+
+// APIClient is a caller authenticated by its API key or, for end users, by a bearer
+// token. End users have no ID; OwnerID is the token subject.
+type APIClient struct {
+	ID     string
+	Name   string
+	Scopes []string
+	// WalletIDs restricts the client to these wallets; empty means every wallet.
+	WalletIDs []string
+	// OwnerID restricts reads and debits to the wallets with this owner_id.
+	OwnerID string
+	// SigningSecret, when set, is the HMAC key the client must sign operations with.
+	SigningSecret string
+}
+
+func (c *APIClient) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == SCOPE_ADMIN {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *APIClient) CanAccessWallet(walletID string) bool {
+	if len(c.WalletIDs) == 0 {
+		return true
+	}
+	for _, id := range c.WalletIDs {
+		if id == walletID {
+			return true
+		}
+	}
+	return false
+}
+
+type apiClientKey struct{}
+
+func withAPIClient(ctx context.Context, client *APIClient) context.Context {
+	if client.ID != "" {
+		ctx = requestctx.WithClientID(ctx, client.ID)
+	} else if client.OwnerID != "" {
+		// End users have no client ID; their operations are recorded by token subject.
+		ctx = requestctx.WithUserID(ctx, client.OwnerID)
+	}
+	return context.WithValue(ctx, apiClientKey{}, client)
+}
+
+func apiClientFromContext(ctx context.Context) (*APIClient, bool) {
+	client, ok := ctx.Value(apiClientKey{}).(*APIClient)
+	return client, ok
+}
+
+// authorize checks that the authenticated client has scope and may touch every
+// wallet in walletIDs. A request without a client is never authorized. End users
+// may deposit to any wallet but only read and debit the wallets they own; a wallet
+// that does not exist is reported as forbidden so that IDs cannot be probed.
+func (h *Handler) authorize(ctx context.Context, scope string, walletIDs ...string) error {
+	if err := authorizeScope(ctx, scope, walletIDs...); err != nil {
+		return err
+	}
+	if scope == SCOPE_DEPOSIT {
+		return nil
+	}
+	return h.authorizeOwner(ctx, walletIDs...)
+}
+
+// authorizeScope is authorize without the ownership check, which needs the database.
+func authorizeScope(ctx context.Context, scope string, walletIDs ...string) error {
+	client, ok := apiClientFromContext(ctx)
+	if !ok || !client.HasScope(scope) {
+		return errForbidden
+	}
+	for _, id := range walletIDs {
+		if !client.CanAccessWallet(canonicalWalletID(id)) {
+			return errForbidden
+		}
+	}
+	return nil
+}
+
+// authorizeOwner checks that an end user owns every wallet in walletIDs. The owners
+// are looked up in a single query; API clients without an owner are not checked.
+func (h *Handler) authorizeOwner(ctx context.Context, walletIDs ...string) error {
+	client, ok := apiClientFromContext(ctx)
+	if !ok || client.OwnerID == "" || len(walletIDs) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(walletIDs))
+	seen := make(map[string]bool, len(walletIDs))
+	for _, id := range walletIDs {
+		id = canonicalWalletID(id)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	owners, err := h.repo.GetWalletOwners(ids, ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if owner, ok := owners[id]; !ok || owner != client.OwnerID {
+			return errForbidden
+		}
+	}
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorizeCredit checks that the authenticated client may credit walletID, e.g. as
+// the target of a TRANSFER. Clients with a wallet allow-list may only credit listed
+// wallets; end users may credit any wallet.
+func authorizeCredit(ctx context.Context, walletID string) error {
+	client, ok := apiClientFromContext(ctx)
+	if !ok || !client.CanAccessWallet(canonicalWalletID(walletID)) {
+		return errForbidden
+	}
+	return nil
+}
+
+// Authenticate rejects requests without a valid X-API-Key or Authorization: Bearer
+// token and attaches the client they belong to to the request context.
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearerPrefix) {
+			if h.jwt == nil {
+				h.lg.ErrorCtx(ctx, "bearer tokens are not configured")
+				writeError(w, r, errUnauthorized)
+				return
+			}
+			subject, err := h.jwt.Verify(strings.TrimPrefix(auth, bearerPrefix))
+			if err != nil {
+				h.lg.ErrorCtx(ctx, fmt.Sprintf("invalid bearer token: %v", err))
+				writeError(w, r, errUnauthorized)
+				return
+			}
+			user := &APIClient{Name: "user", Scopes: userScopes, OwnerID: subject}
+			next.ServeHTTP(w, r.WithContext(withAPIClient(ctx, user)))
+			return
+		}
+
+		key := r.Header.Get(apiKeyHeader)
+		if key == "" {
+			h.lg.ErrorCtx(ctx, "missing api key")
+			writeError(w, r, errUnauthorized)
+			return
+		}
+		client, err := h.repo.GetAPIClient(hashAPIKey(key), ctx)
+		if err == errUnauthorized {
+			h.lg.ErrorCtx(ctx, "invalid api key")
+			writeError(w, r, err)
+			return
+		} else if err != nil {
+			h.lg.ErrorCtx(ctx, "error getting api client")
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withAPIClient(ctx, client)))
+	})
+}
+
+// RequireScope rejects requests whose client lacks scope or, on routes with an {id}
+// parameter, may not access that wallet.
+func (h *Handler) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var walletIDs []string
+			if chi.URLParam(r, "id") != "" {
+				id, ok := h.walletParam(w, r)
+				if !ok {
+					return
+				}
+				walletIDs = append(walletIDs, id)
+			}
+			if err := h.authorize(r.Context(), scope, walletIDs...); err == errForbidden {
+				h.lg.ErrorCtx(r.Context(), "api client lacks scope "+scope)
+				writeError(w, r, err)
+				return
+			} else if err != nil {
+				h.lg.ErrorCtx(r.Context(), "error authorizing request")
+				writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// operationScope is the scope a wallet operation needs. A transfer moves money out
+// of the source wallet, so it needs the withdraw scope.
+func operationScope(operationType string) string {
+	if operationType == DEPOSIT {
+		return SCOPE_DEPOSIT
+	}
+	return SCOPE_WITHDRAW
+}
+
+// GetAPIClient returns the active client whose key hashes to keyHash.
+func (r *Repository) GetAPIClient(keyHash string, ctx context.Context) (*APIClient, error) {
+	client := new(APIClient)
+	err := r.db.QueryRow(ctx, "SELECT id::text, name, scopes, COALESCE(wallet_ids::text[], '{}'), COALESCE(signing_secret, '') FROM api_clients WHERE key_hash = $1 AND revoked_at IS NULL", keyHash).
+		Scan(&client.ID, &client.Name, &client.Scopes, &client.WalletIDs, &client.SigningSecret)
+	if err == pgx.ErrNoRows {
+		return nil, errUnauthorized
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "func getapiclient sql query failed")
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,453 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/jwtauth"
+	"service/internal/problem"
+	"service/internal/requestctx"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var adminClient = &APIClient{ID: "c0a80101-0000-4000-8000-000000000001", Name: "admin", Scopes: []string{SCOPE_ADMIN}}
+
+func withClient(r *http.Request, client *APIClient) *http.Request {
+	return r.WithContext(withAPIClient(r.Context(), client))
+}
+
+func TestAuthenticate(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger}
+
+	reader := &APIClient{ID: "client-1", Scopes: []string{SCOPE_READ_BALANCE}, WalletIDs: []string{walletA}}
+
+	r := chi.NewRouter()
+	r.Use(handler.Authenticate)
+	r.With(handler.RequireScope(SCOPE_READ_BALANCE)).Get("/balance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		clientID, _ := requestctx.ClientIDFromContext(r.Context())
+		w.Write([]byte(clientID))
+	})
+	r.With(handler.RequireScope(SCOPE_ADMIN)).Post("/wallets", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		apiKey         string
+		expectedStatus int
+		expectedCode   string
+		mockRepoFunc   func()
+		mockLoggerFunc func()
+	}{
+		{
+			name:           "Authorized",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			apiKey:         "reader-key",
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("GetAPIClient", hashAPIKey("reader-key"), mock.Anything).Return(reader, nil).Once()
+			},
+			mockLoggerFunc: func() {},
+		},
+		{
+			name:           "Missing Key",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.Unauthorized,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "missing api key").Return().Once()
+			},
+		},
+		{
+			name:           "Unknown Key",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			apiKey:         "stolen-key",
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.Unauthorized,
+			mockRepoFunc: func() {
+				mockRepo.On("GetAPIClient", hashAPIKey("stolen-key"), mock.Anything).Return(nil, errUnauthorized).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid api key").Return().Once()
+			},
+		},
+		{
+			name:           "Wallet Not In Allow List",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletB,
+			apiKey:         "reader-key",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   problem.Forbidden,
+			mockRepoFunc: func() {
+				mockRepo.On("GetAPIClient", hashAPIKey("reader-key"), mock.Anything).Return(reader, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "api client lacks scope read-balance").Return().Once()
+			},
+		},
+		{
+			name:           "Missing Scope",
+			method:         http.MethodPost,
+			path:           "/wallets",
+			apiKey:         "reader-key",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   problem.Forbidden,
+			mockRepoFunc: func() {
+				mockRepo.On("GetAPIClient", hashAPIKey("reader-key"), mock.Anything).Return(reader, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "api client lacks scope admin").Return().Once()
+			},
+		},
+		{
+			name:           "Database Error",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			apiKey:         "reader-key",
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.InternalError,
+			mockRepoFunc: func() {
+				mockRepo.On("GetAPIClient", hashAPIKey("reader-key"), mock.Anything).Return(nil, errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "error getting api client").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set(apiKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedCode != "" {
+				var body problem.Problem
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, tt.expectedCode, body.Code)
+			} else {
+				assert.Equal(t, reader.ID, w.Body.String())
+			}
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleWalletOperation_Scopes(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: defaultMaxOperationAmount}
+
+	depositor := &APIClient{ID: "client-2", Scopes: []string{SCOPE_DEPOSIT}}
+	mockLogger.On("With", "walletId", walletA, "operationType", WITHDRAW).Return(mockLogger).Once()
+	mockLogger.On("ErrorCtx", mock.Anything, "wallet operation forbidden").Return().Once()
+
+	body := `{"walletId":"` + walletA + `","operationType":"WITHDRAW","amount":100,"currency":"RUB"}`
+	req := withClient(httptest.NewRequest(http.MethodPost, "/wallet", strings.NewReader(body)), depositor)
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockRepo.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestRepository_GetAPIClient(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const query = "SELECT id::text, name, scopes, COALESCE(wallet_ids::text[], '{}'), COALESCE(signing_secret, '') FROM api_clients WHERE key_hash = $1 AND revoked_at IS NULL"
+	hash := hashAPIKey("secret")
+
+	mockPool.On("QueryRow", mock.Anything, query, hash).Return(&mockRow{values: []any{"client-1", "k6", []string{SCOPE_DEPOSIT}, []string{}, "s3cret"}}).Once()
+	client, err := repo.GetAPIClient(hash, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &APIClient{ID: "client-1", Name: "k6", Scopes: []string{SCOPE_DEPOSIT}, WalletIDs: []string{}, SigningSecret: "s3cret"}, client)
+
+	mockPool.On("QueryRow", mock.Anything, query, hash).Return(&mockRow{err: pgx.ErrNoRows}).Once()
+	_, err = repo.GetAPIClient(hash, context.Background())
+	assert.Equal(t, errUnauthorized, err)
+
+	mockPool.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func bearerToken(t *testing.T, subject string, expiresIn time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": subject,
+		"exp": time.Now().Add(expiresIn).Unix(),
+	})
+	signed, err := token.SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	return "Bearer " + signed
+}
+
+func TestAuthenticate_BearerToken(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	verifier, err := jwtauth.NewVerifier(jwtauth.Config{HS256Secret: "secret"})
+	assert.NoError(t, err)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, jwt: verifier}
+
+	r := chi.NewRouter()
+	r.Use(handler.Authenticate)
+	r.With(handler.RequireScope(SCOPE_READ_BALANCE)).Get("/balance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// The subject is what the ledger records for the operation.
+		userID, _ := requestctx.UserIDFromContext(r.Context())
+		w.Write([]byte(userID))
+	})
+	r.With(handler.RequireScope(SCOPE_ADMIN)).Post("/wallets", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		authorization  string
+		expectedStatus int
+		expectedCode   string
+		mockRepoFunc   func()
+		mockLoggerFunc func()
+	}{
+		{
+			name:           "Own Wallet",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			authorization:  bearerToken(t, "user-1", time.Hour),
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("GetWalletOwners", []string{walletA}, mock.Anything).Return(map[string]string{walletA: "user-1"}, nil).Once()
+			},
+			mockLoggerFunc: func() {},
+		},
+		{
+			name:           "Someone Else's Wallet",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletB,
+			authorization:  bearerToken(t, "user-1", time.Hour),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   problem.Forbidden,
+			mockRepoFunc: func() {
+				mockRepo.On("GetWalletOwners", []string{walletB}, mock.Anything).Return(map[string]string{walletB: "user-2"}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "api client lacks scope read-balance").Return().Once()
+			},
+		},
+		{
+			name:           "Unknown Wallet",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletB,
+			authorization:  bearerToken(t, "user-1", time.Hour),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   problem.Forbidden,
+			mockRepoFunc: func() {
+				mockRepo.On("GetWalletOwners", []string{walletB}, mock.Anything).Return(map[string]string{}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "api client lacks scope read-balance").Return().Once()
+			},
+		},
+		{
+			name:           "Ownership Lookup Fails",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			authorization:  bearerToken(t, "user-1", time.Hour),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.InternalError,
+			mockRepoFunc: func() {
+				mockRepo.On("GetWalletOwners", []string{walletA}, mock.Anything).Return(map[string]string(nil), errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "error authorizing request").Return().Once()
+			},
+		},
+		{
+			name:           "Malformed Wallet ID",
+			method:         http.MethodGet,
+			path:           "/balance/not-a-uuid",
+			authorization:  bearerToken(t, "user-1", time.Hour),
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.WalletNotFound,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return().Once()
+			},
+		},
+		{
+			name:           "Admin Route",
+			method:         http.MethodPost,
+			path:           "/wallets",
+			authorization:  bearerToken(t, "user-1", time.Hour),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   problem.Forbidden,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "api client lacks scope admin").Return().Once()
+			},
+		},
+		{
+			name:           "Expired Token",
+			method:         http.MethodGet,
+			path:           "/balance/" + walletA,
+			authorization:  bearerToken(t, "user-1", -time.Minute),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.Unauthorized,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, mock.MatchedBy(func(msg string) bool {
+					return strings.HasPrefix(msg, "invalid bearer token: ")
+				})).Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", tt.authorization)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedCode != "" {
+				var body problem.Problem
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, tt.expectedCode, body.Code)
+			} else {
+				assert.Equal(t, "user-1", w.Body.String())
+			}
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticate_BearerTokenNotConfigured(t *testing.T) {
+	mockLogger := new(MockLogger)
+	handler := &Handler{repo: new(MockRepository), lg: mockLogger}
+	mockLogger.On("ErrorCtx", mock.Anything, "bearer tokens are not configured").Return().Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/balance/"+walletA, nil)
+	req.Header.Set("Authorization", bearerToken(t, "user-1", time.Hour))
+	w := httptest.NewRecorder()
+	handler.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestHandleWalletOperation_EndUser(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: defaultMaxOperationAmount}
+	user := &APIClient{Name: "user", Scopes: userScopes, OwnerID: "user-1"}
+
+	// Anyone may be paid, so a deposit does not look up the owner.
+	mockRepo.On("Deposit", walletB, int64(100), "RUB", mock.Anything).Return(nil).Once()
+	mockLogger.On("With", "walletId", walletB, "operationType", DEPOSIT, "amount", int64(100)).Return(mockLogger).Once()
+	mockLogger.On("InfoCtx", mock.Anything, "wallet operation succeeded").Return().Once()
+
+	body := `{"walletId":"` + walletB + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"}`
+	req := withClient(httptest.NewRequest(http.MethodPost, "/wallet", strings.NewReader(body)), user)
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockRepo.On("GetWalletOwners", []string{walletB}, mock.Anything).Return(map[string]string{walletB: "user-2"}, nil).Once()
+	mockLogger.On("With", "walletId", walletB, "operationType", WITHDRAW).Return(mockLogger).Once()
+	mockLogger.On("ErrorCtx", mock.Anything, "wallet operation forbidden").Return().Once()
+
+	body = `{"walletId":"` + walletB + `","operationType":"WITHDRAW","amount":100,"currency":"RUB"}`
+	req = withClient(httptest.NewRequest(http.MethodPost, "/wallet", strings.NewReader(body)), user)
+	w = httptest.NewRecorder()
+	handler.HandleWalletOperation(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	mockRepo.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestHandleBatchOperation_EndUser(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: defaultMaxOperationAmount}
+	user := &APIClient{Name: "user", Scopes: userScopes, OwnerID: "user-1"}
+
+	body := `{"operations":[` +
+		`{"walletId":"` + walletA + `","operationType":"WITHDRAW","amount":100,"currency":"RUB"},` +
+		`{"walletId":"` + walletC + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"},` +
+		`{"walletId":"` + walletA + `","operationType":"TRANSFER","amount":100,"currency":"RUB","targetWalletId":"` + walletC + `"},` +
+		`{"walletId":"` + walletB + `","operationType":"WITHDRAW","amount":100,"currency":"RUB"}]}`
+
+	// The owners of every debited wallet are looked up at once; deposits are not checked.
+	mockRepo.On("GetWalletOwners", []string{walletA, walletB}, mock.Anything).Return(map[string]string{walletA: "user-1", walletB: "user-2"}, nil).Once()
+	mockLogger.On("With", "error", errForbidden).Return(mockLogger).Once()
+	mockLogger.On("ErrorCtx", mock.Anything, "batch wallet ownership check failed").Return().Once()
+
+	req := withClient(httptest.NewRequest(http.MethodPost, "/wallet/batch", strings.NewReader(body)), user)
+	w := httptest.NewRecorder()
+	handler.HandleBatchOperation(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	mockRepo.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestHandleWalletOperation_TransferTargetAllowList(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: defaultMaxOperationAmount}
+
+	restricted := &APIClient{ID: "client-5", Scopes: []string{SCOPE_DEPOSIT, SCOPE_WITHDRAW}, WalletIDs: []string{walletA}}
+	mockLogger.On("With", "targetWalletId", walletB, "operationType", TRANSFER).Return(mockLogger).Once()
+	mockLogger.On("ErrorCtx", mock.Anything, "wallet operation forbidden").Return().Once()
+
+	body := `{"walletId":"` + walletA + `","operationType":"TRANSFER","amount":100,"currency":"RUB","targetWalletId":"` + walletB + `"}`
+	req := withClient(httptest.NewRequest(http.MethodPost, "/wallet", strings.NewReader(body)), restricted)
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	mockLogger.On("With", "operation", 0, "walletId", walletA, "operationType", TRANSFER, "error", errForbidden).Return(mockLogger).Once()
+	mockLogger.On("ErrorCtx", mock.Anything, "batch operation forbidden").Return().Once()
+	body = `{"operations":[` + body + `]}`
+	req = withClient(httptest.NewRequest(http.MethodPost, "/wallet/batch", strings.NewReader(body)), restricted)
+	w = httptest.NewRecorder()
+	handler.HandleBatchOperation(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	mockRepo.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
@@ -0,0 +1,315 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"service/internal/metrics"
+	"service/internal/problem"
+	"service/internal/requestctx"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	guid "github.com/satori/go.uuid"
+)
+
+// Batch modes. An ATOMIC batch applies every operation or none of them; a
+// BEST_EFFORT batch applies the operations that succeed and reports the rest.
+const (
+	ATOMIC      string = "ATOMIC"
+	BEST_EFFORT string = "BEST_EFFORT"
+)
+
+const maxBatchSize = 5000
+
+type BatchRequest struct {
+	Mode       string                   `json:"mode"`
+	Operations []WalletOperationRequest `json:"operations"`
+}
+
+// BatchItemResult is the outcome of one operation, in the order of the request.
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type BatchResponse struct {
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// Validate checks the batch and every operation in it. Field names of operations
+// are prefixed with their position, e.g. operations[3].amount.
+func (req BatchRequest) Validate(maxAmount int64) []problem.FieldError {
+	var errs []problem.FieldError
+	if req.Mode != ATOMIC && req.Mode != BEST_EFFORT {
+		errs = append(errs, problem.FieldError{Field: "mode", Message: fmt.Sprintf("must be one of %s, %s", ATOMIC, BEST_EFFORT)})
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchSize {
+		errs = append(errs, problem.FieldError{Field: "operations", Message: fmt.Sprintf("must contain between 1 and %d operations", maxBatchSize)})
+	}
+	for i := range req.Operations {
+		for _, e := range req.Operations[i].Validate(maxAmount) {
+			errs = append(errs, problem.FieldError{Field: fmt.Sprintf("operations[%d].%s", i, e.Field), Message: e.Message})
+		}
+	}
+	return errs
+}
+
+func (h *Handler) HandleBatchOperation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := BatchRequest{Mode: ATOMIC}
+	if err := decodeJSON(w, r, maxRequestBodySize, &request); err != nil {
+		h.lg.ErrorCtx(ctx, "error decode request body")
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := request.Validate(h.maxAmount); len(errs) > 0 {
+		h.lg.ErrorCtx(ctx, fmt.Sprintf("invalid request: %v", errs))
+		writeValidation(w, r, errs)
+		return
+	}
+
+	// Debited wallets are checked for ownership together once every scope passed.
+	var debited []string
+	for i, op := range request.Operations {
+		scope := operationScope(op.OperationType)
+		err := authorizeScope(ctx, scope, op.WalletID)
+		if err == nil && op.TargetWalletID != "" {
+			err = authorizeCredit(ctx, op.TargetWalletID)
+		}
+		if err != nil {
+			h.lg.With("operation", i, "walletId", op.WalletID, "operationType", op.OperationType, "error", err).ErrorCtx(ctx, "batch operation forbidden")
+			writeError(w, r, err)
+			return
+		}
+		if scope != SCOPE_DEPOSIT {
+			debited = append(debited, op.WalletID)
+		}
+	}
+	if err := h.authorizeOwner(ctx, debited...); err != nil {
+		h.lg.With("error", err).ErrorCtx(ctx, "batch wallet ownership check failed")
+		writeError(w, r, err)
+		return
+	}
+
+	atomic := request.Mode == ATOMIC
+	results, err := h.repo.ApplyBatch(request.Operations, atomic, ctx)
+	if err != nil {
+		h.lg.With("mode", request.Mode, "error", err).ErrorCtx(ctx, "batch failed")
+		writeError(w, r, err)
+		return
+	}
+
+	if atomic {
+		for i, opErr := range results {
+			if opErr == nil {
+				continue
+			}
+			// Nothing was applied, so the whole batch fails like a single operation would.
+			op := request.Operations[i]
+			metrics.ObserveOperation(op.OperationType, operationOutcome(opErr), op.Amount)
+			h.lg.With("operation", i, "walletId", op.WalletID, "operationType", op.OperationType, "amount", op.Amount, "error", opErr).ErrorCtx(ctx, "batch operation failed")
+			status, code, detail := problemFor(opErr)
+			problem.Write(w, r, status, code, fmt.Sprintf("operation %d: %s", i, detail))
+			return
+		}
+	}
+
+	response := BatchResponse{Mode: request.Mode, Results: make([]BatchItemResult, len(results))}
+	for i, opErr := range results {
+		op := request.Operations[i]
+		metrics.ObserveOperation(op.OperationType, operationOutcome(opErr), op.Amount)
+		if opErr == nil {
+			response.Results[i] = BatchItemResult{Index: i, Status: http.StatusOK}
+			response.Succeeded++
+			continue
+		}
+		status, code, detail := problemFor(opErr)
+		response.Results[i] = BatchItemResult{Index: i, Status: status, Code: code, Detail: detail}
+		response.Failed++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	h.lg.With("mode", response.Mode, "succeeded", response.Succeeded, "failed", response.Failed).InfoCtx(ctx, "batch succeeded")
+}
+
+// batchWallet is the state of a locked wallet while a batch is applied to it.
+type batchWallet struct {
+	balance  int64
+	held     int64
+	delta    int64
+	status   string
+	currency string
+}
+
+// ApplyBatch applies operations in one database transaction and returns the outcome
+// of each of them. The wallets involved are locked up front, the operations are
+// checked against their balances in memory and all writes are sent as a single
+// pgx batch. In atomic mode the first failed operation rolls back the whole batch;
+// its error is the only non-nil result.
+func (r *Repository) ApplyBatch(operations []WalletOperationRequest, atomic bool, ctx context.Context) ([]error, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func applybatch begin transaction failed")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	wallets, err := r.lockBatchWallets(tx, operations, ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func applybatch lock wallets failed")
+		return nil, err
+	}
+
+	results := make([]error, len(operations))
+	var ledger []Transaction
+	for i, op := range operations {
+		rows, err := applyBatchOperation(wallets, op)
+		if err != nil {
+			results[i] = err
+			if atomic {
+				return results, nil
+			}
+			continue
+		}
+		ledger = append(ledger, rows...)
+	}
+	if len(ledger) == 0 {
+		return results, nil
+	}
+
+	requestID, _ := requestctx.RequestIDFromContext(ctx)
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	userID, _ := requestctx.UserIDFromContext(ctx)
+	batch := &pgx.Batch{}
+	ids := make([]string, 0, len(wallets))
+	for id := range wallets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if wallets[id].delta != 0 {
+			batch.Queue("UPDATE wallets SET balance = balance + $1 WHERE id = $2", wallets[id].delta, id)
+		}
+	}
+	for _, t := range ledger {
+		batch.Queue(insertTransactionSQL, t.WalletID, t.OperationType, t.Amount, t.BalanceAfter, t.CounterpartyWalletID, requestID, clientID, userID)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		r.lg.ErrorCtx(ctx, "func applybatch sql query failed")
+		return nil, err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func applybatch commit failed")
+		return nil, err
+	}
+	return results, nil
+}
+
+// lockBatchWallets locks every wallet the operations touch, in primary key order.
+func (r *Repository) lockBatchWallets(tx pgx.Tx, operations []WalletOperationRequest, ctx context.Context) (map[string]*batchWallet, error) {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, op := range operations {
+		for _, id := range []string{canonicalWalletID(op.WalletID), canonicalWalletID(op.TargetWalletID)} {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	rows, err := tx.Query(ctx, "SELECT w.id::text, w.balance, "+heldAmountSQL+", w.status, w.currency FROM wallets w WHERE w.id IN (SELECT unnest($1::text[])::uuid) ORDER BY w.id FOR UPDATE", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wallets := make(map[string]*batchWallet, len(ids))
+	for rows.Next() {
+		var id string
+		wallet := new(batchWallet)
+		if err := rows.Scan(&id, &wallet.balance, &wallet.held, &wallet.status, &wallet.currency); err != nil {
+			return nil, err
+		}
+		wallets[id] = wallet
+	}
+	return wallets, rows.Err()
+}
+
+// applyBatchOperation applies op to the locked wallets and returns the ledger rows it
+// produces. The wallets are left untouched when the operation fails.
+func applyBatchOperation(wallets map[string]*batchWallet, op WalletOperationRequest) ([]Transaction, error) {
+	op.WalletID = canonicalWalletID(op.WalletID)
+	op.TargetWalletID = canonicalWalletID(op.TargetWalletID)
+	source, err := batchWalletFor(wallets, op.WalletID, op.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.OperationType {
+	case DEPOSIT:
+		source.credit(op.Amount)
+		return []Transaction{{WalletID: op.WalletID, OperationType: DEPOSIT, Amount: op.Amount, BalanceAfter: source.balance}}, nil
+	case WITHDRAW:
+		if source.balance-source.held < op.Amount {
+			return nil, errInsufficientFunds
+		}
+		source.credit(-op.Amount)
+		return []Transaction{{WalletID: op.WalletID, OperationType: WITHDRAW, Amount: op.Amount, BalanceAfter: source.balance}}, nil
+	case TRANSFER:
+		target, err := batchWalletFor(wallets, op.TargetWalletID, source.currency)
+		if err != nil {
+			return nil, err
+		}
+		if source.balance-source.held < op.Amount {
+			return nil, errInsufficientFunds
+		}
+		source.credit(-op.Amount)
+		target.credit(op.Amount)
+		return []Transaction{
+			{WalletID: op.WalletID, OperationType: TRANSFER_OUT, Amount: op.Amount, BalanceAfter: source.balance, CounterpartyWalletID: op.TargetWalletID},
+			{WalletID: op.TargetWalletID, OperationType: TRANSFER_IN, Amount: op.Amount, BalanceAfter: target.balance, CounterpartyWalletID: op.WalletID},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown operation type %q", op.OperationType)
+}
+
+// batchWalletFor returns a locked active wallet; a non-empty currency must match its currency.
+func batchWalletFor(wallets map[string]*batchWallet, walletID, currency string) (*batchWallet, error) {
+	wallet, ok := wallets[walletID]
+	if !ok {
+		return nil, errWalletid
+	}
+	if wallet.status != ACTIVE {
+		return nil, errWalletClosed
+	}
+	if currency != "" && currency != wallet.currency {
+		return nil, errCurrencyMismatch
+	}
+	return wallet, nil
+}
+
+// canonicalWalletID returns id in the lowercase hyphenated form Postgres reports
+// wallet IDs in, so that IDs from requests can be matched with locked wallets. An
+// ID that is not a UUID is returned unchanged.
+func canonicalWalletID(id string) string {
+	parsed, err := guid.FromString(id)
+	if err != nil {
+		return id
+	}
+	return parsed.String()
+}
+
+func (w *batchWallet) credit(amount int64) {
+	w.balance += amount
+	w.delta += amount
+}
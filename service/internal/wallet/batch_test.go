@@ -0,0 +1,307 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/problem"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleBatchOperation(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: 1000}
+
+	deposit := WalletOperationRequest{WalletID: walletA, OperationType: DEPOSIT, Amount: 100, Currency: "RUB"}
+	withdraw := WalletOperationRequest{WalletID: walletB, OperationType: WITHDRAW, Amount: 500, Currency: "RUB"}
+
+	tests := []struct {
+		name            string
+		body            string
+		expectedStatus  int
+		expectedCode    string
+		expectedResults []BatchItemResult
+		mockRepoFunc    func()
+		mockLoggerFunc  func()
+	}{
+		{
+			name:           "Atomic Batch",
+			body:           `{"operations":[{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"},{"walletId":"` + walletB + `","operationType":"WITHDRAW","amount":500,"currency":"RUB"}]}`,
+			expectedStatus: http.StatusOK,
+			expectedResults: []BatchItemResult{
+				{Index: 0, Status: http.StatusOK},
+				{Index: 1, Status: http.StatusOK},
+			},
+			mockRepoFunc: func() {
+				mockRepo.On("ApplyBatch", []WalletOperationRequest{deposit, withdraw}, true, mock.Anything).Return([]error{nil, nil}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "mode", ATOMIC, "succeeded", 2, "failed", 0).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "batch succeeded").Return().Once()
+			},
+		},
+		{
+			name:           "Atomic Batch Aborted",
+			body:           `{"mode":"ATOMIC","operations":[{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"},{"walletId":"` + walletB + `","operationType":"WITHDRAW","amount":500,"currency":"RUB"}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.InsufficientFunds,
+			mockRepoFunc: func() {
+				mockRepo.On("ApplyBatch", []WalletOperationRequest{deposit, withdraw}, true, mock.Anything).Return([]error{nil, errInsufficientFunds}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "operation", 1, "walletId", walletB, "operationType", WITHDRAW, "amount", int64(500), "error", errInsufficientFunds).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "batch operation failed").Return().Once()
+			},
+		},
+		{
+			name:           "Best Effort Batch",
+			body:           `{"mode":"BEST_EFFORT","operations":[{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"},{"walletId":"` + walletB + `","operationType":"WITHDRAW","amount":500,"currency":"RUB"}]}`,
+			expectedStatus: http.StatusOK,
+			expectedResults: []BatchItemResult{
+				{Index: 0, Status: http.StatusOK},
+				{Index: 1, Status: http.StatusUnprocessableEntity, Code: problem.InsufficientFunds, Detail: "insufficient funds"},
+			},
+			mockRepoFunc: func() {
+				mockRepo.On("ApplyBatch", []WalletOperationRequest{deposit, withdraw}, false, mock.Anything).Return([]error{nil, errInsufficientFunds}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "mode", BEST_EFFORT, "succeeded", 1, "failed", 1).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "batch succeeded").Return().Once()
+			},
+		},
+		{
+			name:           "Invalid Operations",
+			body:           `{"mode":"PARTIAL","operations":[{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"},{"walletId":"` + walletB + `","operationType":"WITHDRAW","amount":0,"currency":"RUB"}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{mode must be one of ATOMIC, BEST_EFFORT} {operations[1].amount must be positive}]").Return().Once()
+			},
+		},
+		{
+			name:           "Empty Batch",
+			body:           `{"operations":[]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{operations must contain between 1 and 5000 operations}]").Return().Once()
+			},
+		},
+		{
+			name:           "Database Error",
+			body:           `{"operations":[{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"}]}`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.InternalError,
+			mockRepoFunc: func() {
+				mockRepo.On("ApplyBatch", []WalletOperationRequest{deposit}, true, mock.Anything).Return([]error(nil), errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "mode", ATOMIC, "error", errors.New("db error")).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "batch failed").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := withClient(httptest.NewRequest(http.MethodPost, "/wallet/batch", strings.NewReader(tt.body)), adminClient)
+			w := httptest.NewRecorder()
+
+			handler.HandleBatchOperation(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedResults != nil {
+				var body BatchResponse
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, tt.expectedResults, body.Results)
+			} else {
+				var body problem.Problem
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, tt.expectedCode, body.Code)
+			}
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_ApplyBatch(t *testing.T) {
+	mockLogger := new(MockLogger)
+
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		lockQuery   = "SELECT w.id::text, w.balance, " + heldAmountSQL + ", w.status, w.currency FROM wallets w WHERE w.id IN (SELECT unnest($1::text[])::uuid) ORDER BY w.id FOR UPDATE"
+		updateQuery = "UPDATE wallets SET balance = balance + $1 WHERE id = $2"
+	)
+	operations := []WalletOperationRequest{
+		{WalletID: "a", OperationType: DEPOSIT, Amount: 100},
+		{WalletID: "b", OperationType: WITHDRAW, Amount: 80},
+		{WalletID: "a", OperationType: TRANSFER, Amount: 150, TargetWalletID: "b"},
+		{WalletID: "c", OperationType: DEPOSIT, Amount: 10},
+	}
+	lockedRows := func() *mockRows {
+		return &mockRows{rows: [][]any{
+			{"a", int64(100), int64(0), ACTIVE, "RUB"},
+			{"b", int64(100), int64(50), ACTIVE, "RUB"},
+		}}
+	}
+	queued := func(b *pgx.Batch) [][]any {
+		var queries [][]any
+		for _, q := range b.QueuedQueries {
+			queries = append(queries, append([]any{q.SQL}, q.Arguments...))
+		}
+		return queries
+	}
+
+	tests := []struct {
+		name            string
+		atomic          bool
+		mockSetup       func(tx *MockTx)
+		mockLoggerFunc  func()
+		expectedResults []error
+		expectedErr     error
+	}{
+		{
+			name:   "Best Effort",
+			atomic: false,
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, []string{"a", "b", "c"}).Return(lockedRows(), nil).Once()
+				tx.On("SendBatch", mock.Anything, mock.MatchedBy(func(b *pgx.Batch) bool {
+					return assert.ObjectsAreEqual([][]any{
+						{updateQuery, int64(-50), "a"},
+						{updateQuery, int64(150), "b"},
+						{insertTransactionSQL, "a", DEPOSIT, int64(100), int64(200), "", "", "", ""},
+						{insertTransactionSQL, "a", TRANSFER_OUT, int64(150), int64(50), "b", "", "", ""},
+						{insertTransactionSQL, "b", TRANSFER_IN, int64(150), int64(250), "a", "", "", ""},
+					}, queued(b))
+				})).Return(&mockBatchResults{}).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc:  func() {},
+			expectedResults: []error{nil, errInsufficientFunds, nil, errWalletid},
+			expectedErr:     nil,
+		},
+		{
+			name:   "Atomic Aborted",
+			atomic: true,
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, []string{"a", "b", "c"}).Return(lockedRows(), nil).Once()
+			},
+			mockLoggerFunc:  func() {},
+			expectedResults: []error{nil, errInsufficientFunds, nil, nil},
+			expectedErr:     nil,
+		},
+		{
+			name:   "Batch Error",
+			atomic: false,
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, []string{"a", "b", "c"}).Return(lockedRows(), nil).Once()
+				tx.On("SendBatch", mock.Anything, mock.Anything).Return(&mockBatchResults{err: errors.New("db error")}).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func applybatch sql query failed").Return().Once()
+			},
+			expectedResults: nil,
+			expectedErr:     errors.New("db error"),
+		},
+		{
+			name:   "Lock Error",
+			atomic: true,
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, []string{"a", "b", "c"}).Return((*mockRows)(nil), errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func applybatch lock wallets failed").Return().Once()
+			},
+			expectedResults: nil,
+			expectedErr:     errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTx := new(MockTx)
+			mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+			mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+			tt.mockSetup(mockTx)
+			tt.mockLoggerFunc()
+
+			results, err := repo.ApplyBatch(operations, tt.atomic, context.Background())
+
+			assert.Equal(t, tt.expectedResults, results)
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockPool.AssertExpectations(t)
+			mockTx.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_ApplyBatch_NonCanonicalIDs(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockPool := new(MockPool)
+	mockTx := new(MockTx)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		lockQuery   = "SELECT w.id::text, w.balance, " + heldAmountSQL + ", w.status, w.currency FROM wallets w WHERE w.id IN (SELECT unnest($1::text[])::uuid) ORDER BY w.id FOR UPDATE"
+		updateQuery = "UPDATE wallets SET balance = balance + $1 WHERE id = $2"
+	)
+	// The same two wallets, each spelled two ways.
+	operations := []WalletOperationRequest{
+		{WalletID: strings.ToUpper(walletA), OperationType: DEPOSIT, Amount: 100},
+		{WalletID: "{" + walletA + "}", OperationType: TRANSFER, Amount: 50, TargetWalletID: strings.ReplaceAll(walletB, "-", "")},
+	}
+
+	mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+	mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+	mockTx.On("Query", mock.Anything, lockQuery, []string{walletA, walletB}).Return(&mockRows{rows: [][]any{
+		{walletA, int64(0), int64(0), ACTIVE, "RUB"},
+		{walletB, int64(0), int64(0), ACTIVE, "RUB"},
+	}}, nil).Once()
+	mockTx.On("SendBatch", mock.Anything, mock.MatchedBy(func(b *pgx.Batch) bool {
+		var queries [][]any
+		for _, q := range b.QueuedQueries {
+			queries = append(queries, append([]any{q.SQL}, q.Arguments...))
+		}
+		return assert.ObjectsAreEqual([][]any{
+			{updateQuery, int64(50), walletA},
+			{updateQuery, int64(50), walletB},
+			{insertTransactionSQL, walletA, DEPOSIT, int64(100), int64(100), "", "", "", ""},
+			{insertTransactionSQL, walletA, TRANSFER_OUT, int64(50), int64(50), walletB, "", "", ""},
+			{insertTransactionSQL, walletB, TRANSFER_IN, int64(50), int64(50), walletA, "", "", ""},
+		}, queries)
+	})).Return(&mockBatchResults{}).Once()
+	mockTx.On("Commit", mock.Anything).Return(nil).Once()
+
+	results, err := repo.ApplyBatch(operations, true, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, results)
+
+	mockPool.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
@@ -0,0 +1,49 @@
+package wallet
+
+import (
+	"errors"
+	"math"
+)
+
+// defaultCurrency is used for wallets created without a currency.
+const defaultCurrency = "RUB"
+
+// currencyMinorUnits lists the ISO 4217 currencies a wallet can hold with the number
+// of decimal places of each. Every amount in the API is an integer of minor units,
+// e.g. 1050 RUB is 10.50 roubles and 1050 JPY is 1050 yen.
+var currencyMinorUnits = map[string]int{
+	"RUB": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"KZT": 2,
+	"BYN": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+var errCurrencyMismatch = errors.New("currency does not match the wallet currency")
+
+func validCurrency(code string) bool {
+	_, ok := currencyMinorUnits[code]
+	return ok
+}
+
+// maxAmountIn converts a cap given in major units to minor units of currency. Zero
+// means no cap; a cap too large for int64 saturates at math.MaxInt64.
+func maxAmountIn(currency string, maxAmount int64) int64 {
+	if maxAmount <= 0 {
+		return 0
+	}
+	for range currencyMinorUnits[currency] {
+		if maxAmount > math.MaxInt64/10 {
+			return math.MaxInt64
+		}
+		maxAmount *= 10
+	}
+	return maxAmount
+}
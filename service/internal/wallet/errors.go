@@ -0,0 +1,95 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"service/internal/metrics"
+	"service/internal/problem"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// queryCanceled is the SQLSTATE Postgres reports when statement_timeout cancels a query.
+const queryCanceled = "57014"
+
+var (
+	errTimeout = errors.New("database did not respond in time")
+	// errCommitUncertain marks a commit that timed out and may still have been applied.
+	errCommitUncertain = errors.New("commit outcome is unknown")
+)
+
+// errorProblems maps the errors the repository returns on purpose to problem responses.
+// Anything else is reported as an internal error without leaking database details.
+var errorProblems = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{errWalletid, http.StatusNotFound, problem.WalletNotFound},
+	{errInsufficientFunds, http.StatusUnprocessableEntity, problem.InsufficientFunds},
+	{errCurrencyMismatch, http.StatusUnprocessableEntity, problem.CurrencyMismatch},
+	{errWalletClosed, http.StatusConflict, problem.WalletClosed},
+	{errWalletNotEmpty, http.StatusConflict, problem.WalletNotEmpty},
+	{errExternalRefExists, http.StatusConflict, problem.ExternalRefExists},
+	{errIdempotencyKeyReused, http.StatusConflict, problem.IdempotencyKeyReused},
+	{errIdempotencyKeyInProgress, http.StatusConflict, problem.IdempotencyInProgress},
+	{errHoldNotFound, http.StatusNotFound, problem.HoldNotFound},
+	{errHoldNotActive, http.StatusConflict, problem.HoldNotActive},
+	{errCaptureExceedsHold, http.StatusUnprocessableEntity, problem.CaptureExceedsHold},
+	{errUnauthorized, http.StatusUnauthorized, problem.Unauthorized},
+	{errForbidden, http.StatusForbidden, problem.Forbidden},
+	{errInvalidSignature, http.StatusUnauthorized, problem.InvalidSignature},
+	{errStaleSignature, http.StatusUnauthorized, problem.InvalidSignature},
+	{errRequestReplayed, http.StatusConflict, problem.RequestReplayed},
+}
+
+// problemFor returns the status, code and detail reported to the client for err.
+func problemFor(err error) (int, string, string) {
+	if isTimeout(err) {
+		return http.StatusServiceUnavailable, problem.ServiceUnavailable, errTimeout.Error()
+	}
+	for _, p := range errorProblems {
+		if errors.Is(err, p.err) {
+			return p.status, p.code, p.err.Error()
+		}
+	}
+	return http.StatusInternalServerError, problem.InternalError, "internal server error"
+}
+
+// operationOutcome classifies the result of a wallet operation for metrics.
+func operationOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.Is(err, errWalletid):
+		return metrics.OutcomeNotFound
+	case errors.Is(err, errInsufficientFunds):
+		return metrics.OutcomeInsufficientFunds
+	case isTimeout(err):
+		return metrics.OutcomeTimeout
+	}
+	for _, p := range errorProblems {
+		if errors.Is(err, p.err) {
+			return metrics.OutcomeRejected
+		}
+	}
+	return metrics.OutcomeDBError
+}
+
+// isTimeout reports whether err comes from a request, operation or statement deadline.
+func isTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == queryCanceled
+	}
+	return errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if rec, ok := w.(*responseRecorder); ok && errors.Is(err, errCommitUncertain) {
+		rec.commitUncertain = true
+	}
+	status, code, detail := problemFor(err)
+	problem.Write(w, r, status, code, detail)
+}
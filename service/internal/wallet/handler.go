@@ -2,107 +2,304 @@ package wallet
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"service/internal/config"
+	"service/internal/jwtauth"
 	"service/internal/logger"
+	"service/internal/metrics"
+	"service/internal/problem"
+	"strconv"
 	"sync"
-
-	"github.com/go-chi/chi/v5"
+	"time"
 )
 
 const (
 	DEPOSIT  string = "DEPOSIT"
 	WITHDRAW string = "WITHDRAW"
+	TRANSFER string = "TRANSFER"
+)
+
+// ledgerOperationTypes are the operation types a ledger row can carry.
+var ledgerOperationTypes = map[string]bool{
+	DEPOSIT:      true,
+	WITHDRAW:     true,
+	TRANSFER_IN:  true,
+	TRANSFER_OUT: true,
+	CAPTURE:      true,
+}
+
+const (
+	defaultTransactionsLimit = 50
+	maxTransactionsLimit     = 500
 )
 
 type WalletOperationRequest struct {
 	WalletID      string `json:"walletId"`
 	OperationType string `json:"operationType"`
-	Amount        int64  `json:"amount"`
+	// Amount is given in minor units of the wallet currency.
+	Amount int64 `json:"amount"`
+	// Currency is required and must match the wallet currency, so that Amount
+	// is never read in the minor units of another currency.
+	Currency string `json:"currency"`
+	// TargetWalletID is the credited wallet of a TRANSFER.
+	TargetWalletID string `json:"targetWalletId,omitempty"`
+}
+
+type TransactionsPage struct {
+	WalletID     string        `json:"walletId"`
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"nextCursor,omitempty"`
 }
 
 type Handler struct {
-	repo RepositoryInterface
-	mu   sync.Mutex
-	lg   logger.Logger
-	ctx  context.Context
+	repo           RepositoryInterface
+	mu             sync.Mutex
+	lg             logger.Logger
+	idempotencyTTL time.Duration
+	// idempotencyLease is how long a request may hold its key before it can be reclaimed.
+	idempotencyLease time.Duration
+	maxAmount        int64
+	signatureSkew    time.Duration
+	done             chan struct{}
+	// jwt verifies bearer tokens; nil when none are accepted.
+	jwt *jwtauth.Verifier
 }
 
 func NewHandler(lg logger.Logger, ctx context.Context, cfg *config.ConfigAdr) *Handler {
-	return &Handler{
-		repo: NewRepository(lg, ctx, cfg),
-		lg:   lg,
-		ctx:  ctx,
+	h := &Handler{
+		repo:             newTracedRepository(newTimeoutRepository(NewRepository(lg, ctx, cfg), cfg.Operation_timeout)),
+		lg:               lg,
+		idempotencyTTL:   cfg.Idempotency_ttl,
+		idempotencyLease: cfg.Idempotency_lease,
+		maxAmount:        cfg.Max_operation_amount,
+		signatureSkew:    cfg.Signature_max_skew,
+		done:             make(chan struct{}),
+	}
+	if h.idempotencyTTL <= 0 {
+		h.idempotencyTTL = defaultIdempotencyTTL
 	}
+	if h.idempotencyLease <= 0 {
+		h.idempotencyLease = defaultIdempotencyLease
+	}
+	if h.maxAmount <= 0 {
+		h.maxAmount = defaultMaxOperationAmount
+	}
+	if cfg.Jwt_hs256_secret != "" || cfg.Jwt_rs256_public_key_file != "" || cfg.Jwt_jwks_file != "" {
+		verifier, err := jwtauth.NewVerifier(jwtauth.Config{
+			HS256Secret:        cfg.Jwt_hs256_secret,
+			RS256PublicKeyFile: cfg.Jwt_rs256_public_key_file,
+			JWKSFile:           cfg.Jwt_jwks_file,
+			Issuer:             cfg.Jwt_issuer,
+			Audience:           cfg.Jwt_audience,
+		})
+		if err != nil {
+			lg.FatalCtx(ctx, "Error loading JWT keys", err)
+		}
+		h.jwt = verifier
+	}
+	go h.purgeIdempotencyKeys(ctx)
+	go h.expireHolds(ctx)
+	go h.purgeNonces(ctx)
+	return h
 }
 
 func (h *Handler) Close() {
+	close(h.done)
 	h.repo.Close()
 }
 
 func (h *Handler) HandleWalletOperation(w http.ResponseWriter, r *http.Request) {
 	var request WalletOperationRequest
-	h.ctx = r.Context()
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		h.lg.ErrorCtx(h.ctx, "error decode request body")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	ctx := r.Context()
+	if err := decodeJSON(w, r, maxOperationBodySize, &request); err != nil {
+		h.lg.ErrorCtx(ctx, "error decode request body")
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := request.Validate(h.maxAmount); len(errs) > 0 {
+		h.lg.ErrorCtx(ctx, fmt.Sprintf("invalid request: %v", errs))
+		writeValidation(w, r, errs)
 		return
 	}
 
-	if request.OperationType == DEPOSIT {
-		if err := h.repo.Deposit(request.WalletID, request.Amount, h.ctx); err != nil {
-			if err == errWalletid {
-				h.lg.ErrorCtx(h.ctx, "insufficient funds or walletid not found")
-				http.Error(w, err.Error(), http.StatusNotFound)
-				return
-			}
-			h.lg.ErrorCtx(h.ctx, fmt.Sprintf("deposit err = %v", err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.authorize(ctx, operationScope(request.OperationType), request.WalletID); err != nil {
+		h.lg.With("walletId", request.WalletID, "operationType", request.OperationType).ErrorCtx(ctx, "wallet operation forbidden")
+		writeError(w, r, err)
+		return
+	}
+	if request.TargetWalletID != "" {
+		if err := authorizeCredit(ctx, request.TargetWalletID); err != nil {
+			h.lg.With("targetWalletId", request.TargetWalletID, "operationType", request.OperationType).ErrorCtx(ctx, "wallet operation forbidden")
+			writeError(w, r, err)
 			return
 		}
+	}
+
+	var err error
+	if request.OperationType == DEPOSIT {
+		err = h.repo.Deposit(request.WalletID, request.Amount, request.Currency, ctx)
 	} else if request.OperationType == WITHDRAW {
-		if err := h.repo.Withdraw(request.WalletID, request.Amount, h.ctx); err != nil {
-			if err == errWithdraw {
-				h.lg.ErrorCtx(h.ctx, "insufficient funds or walletid not found")
-				http.Error(w, err.Error(), http.StatusNotFound)
-				return
-			}
-			h.lg.ErrorCtx(h.ctx, fmt.Sprintf("withdraw err = %v", err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		h.lg.ErrorCtx(h.ctx, "invalid operation type")
-		http.Error(w, "invalid operation type", http.StatusBadRequest)
+		err = h.repo.Withdraw(request.WalletID, request.Amount, request.Currency, ctx)
+	} else if request.OperationType == TRANSFER {
+		err = h.repo.Transfer(request.WalletID, request.TargetWalletID, request.Amount, request.Currency, ctx)
+	}
+	metrics.ObserveOperation(request.OperationType, operationOutcome(err), request.Amount)
+	fields := []any{"walletId", request.WalletID, "operationType", request.OperationType, "amount", request.Amount}
+	if request.TargetWalletID != "" {
+		fields = append(fields, "targetWalletId", request.TargetWalletID)
+	}
+	lg := h.lg.With(fields...)
+	if err != nil {
+		lg.With("error", err).ErrorCtx(ctx, "wallet operation failed")
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	h.lg.InfoCtx(h.ctx, fmt.Sprintf("wallet id = %s, operation = %s , amount = %d is success", request.WalletID, request.OperationType, request.Amount))
+	lg.InfoCtx(ctx, "wallet operation succeeded")
 }
 
 func (h *Handler) GetWalletBalance(w http.ResponseWriter, r *http.Request) {
-	h.ctx = r.Context()
-	walletID := chi.URLParam(r, "id")
-	h.lg.DebugCtx(h.ctx, fmt.Sprintf("walletId=%v", walletID))
+	ctx := r.Context()
+	walletID, ok := h.walletParam(w, r)
+	if !ok {
+		return
+	}
+	lg := h.lg.With("walletId", walletID)
+	lg.DebugCtx(ctx, "getting balance")
 
-	balance, err := h.repo.GetBalance(walletID, h.ctx)
+	balance, err := h.repo.GetBalance(walletID, ctx)
 	if err == errWalletid {
-		h.lg.ErrorCtx(h.ctx, "walletid not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+		lg.ErrorCtx(ctx, "walletid not found")
+		writeError(w, r, err)
 		return
 	} else if err != nil {
-		h.lg.ErrorCtx(h.ctx, "error getting balance")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		lg.ErrorCtx(ctx, "error getting balance")
+		writeError(w, r, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"walletId": walletID,
-		"balance":  balance,
+		"walletId":   walletID,
+		"ledger":     balance.Ledger,
+		"available":  balance.Available,
+		"currency":   balance.Currency,
+		"minorUnits": balance.MinorUnits,
 	})
-	w.WriteHeader(http.StatusOK)
-	h.lg.InfoCtx(h.ctx, fmt.Sprintf("wallet id = %s, balance = %d is success", walletID, balance))
+	lg.With("ledger", balance.Ledger, "available", balance.Available).InfoCtx(ctx, "balance retrieved")
+}
+
+func (h *Handler) GetWalletTransactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	walletID, ok := h.walletParam(w, r)
+	if !ok {
+		return
+	}
+	lg := h.lg.With("walletId", walletID)
+	lg.DebugCtx(ctx, "getting transactions")
+
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		lg.With("error", err).ErrorCtx(ctx, "invalid transactions filter")
+		problem.Write(w, r, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+	// Ask for one extra row to find out whether another page exists.
+	limit := filter.Limit
+	filter.Limit = limit + 1
+
+	transactions, err := h.repo.GetTransactions(walletID, filter, ctx)
+	if err == errWalletid {
+		lg.ErrorCtx(ctx, "walletid not found")
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		lg.ErrorCtx(ctx, "error getting transactions")
+		writeError(w, r, err)
+		return
+	}
+
+	page := TransactionsPage{WalletID: walletID, Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		page.NextCursor = encodeCursor(page.Transactions[limit-1].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+	lg.With("transactions", len(page.Transactions)).InfoCtx(ctx, "transactions retrieved")
+}
+
+func parseTransactionFilter(query url.Values) (TransactionFilter, error) {
+	filter := TransactionFilter{Limit: defaultTransactionsLimit}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTransactionsLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxTransactionsLimit)
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return filter, errors.New("invalid cursor")
+		}
+		filter.Cursor = cursor
+	}
+	if v := query.Get("operationType"); v != "" {
+		if !ledgerOperationTypes[v] {
+			return filter, errors.New("invalid operation type")
+		}
+		filter.OperationType = v
+	}
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+	for name, dst := range map[string]**int64{"minAmount": &filter.MinAmount, "maxAmount": &filter.MaxAmount} {
+		if v := query.Get(name); v != "" {
+			amount, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an integer", name)
+			}
+			*dst = &amount
+		}
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		return filter, errors.New("minAmount must not exceed maxAmount")
+	}
+	return filter, nil
+}
+
+// Cursors are opaque to clients; they wrap the ID of the last transaction on a page.
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
 }
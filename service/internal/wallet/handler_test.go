@@ -8,32 +8,145 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"service/internal/logger"
+	"service/internal/problem"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
+const (
+	walletA = "5307598b-afda-4fa9-8eb8-b3af06bdb310"
+	walletB = "b49c66cb-90f8-4ad7-b2b3-fd993e9d9efd"
+	walletC = "0f1c3a52-7d3e-4c39-9d55-3a3f4c8a1e2b"
+)
+
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) Deposit(walletID string, amount int64, ctx context.Context) error {
-	args := m.Called(walletID, amount, ctx)
+func (m *MockRepository) Deposit(walletID string, amount int64, currency string, ctx context.Context) error {
+	args := m.Called(walletID, amount, currency, ctx)
+	return args.Error(0)
+}
+
+func (m *MockRepository) Withdraw(walletID string, amount int64, currency string, ctx context.Context) error {
+	args := m.Called(walletID, amount, currency, ctx)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Withdraw(walletID string, amount int64, ctx context.Context) error {
-	args := m.Called(walletID, amount, ctx)
+func (m *MockRepository) Transfer(fromWalletID, toWalletID string, amount int64, currency string, ctx context.Context) error {
+	args := m.Called(fromWalletID, toWalletID, amount, currency, ctx)
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetBalance(walletID string, ctx context.Context) (int64, error) {
+func (m *MockRepository) GetBalance(walletID string, ctx context.Context) (Balance, error) {
+	args := m.Called(walletID, ctx)
+	return args.Get(0).(Balance), args.Error(1)
+}
+
+func (m *MockRepository) CreateWallet(ownerID, externalRef, currency string, ctx context.Context) (*Wallet, error) {
+	args := m.Called(ownerID, externalRef, currency, ctx)
+	return args.Get(0).(*Wallet), args.Error(1)
+}
+
+func (m *MockRepository) GetWallet(walletID string, ctx context.Context) (*Wallet, error) {
+	args := m.Called(walletID, ctx)
+	return args.Get(0).(*Wallet), args.Error(1)
+}
+
+func (m *MockRepository) GetWalletOwners(walletIDs []string, ctx context.Context) (map[string]string, error) {
+	args := m.Called(walletIDs, ctx)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
+func (m *MockRepository) CloseWallet(walletID string, ctx context.Context) (*Wallet, error) {
 	args := m.Called(walletID, ctx)
+	return args.Get(0).(*Wallet), args.Error(1)
+}
+
+func (m *MockRepository) ApplyBatch(operations []WalletOperationRequest, atomic bool, ctx context.Context) ([]error, error) {
+	args := m.Called(operations, atomic, ctx)
+	return args.Get(0).([]error), args.Error(1)
+}
+
+func (m *MockRepository) CreateHold(walletID string, amount int64, currency string, ttl time.Duration, ctx context.Context) (*Hold, error) {
+	args := m.Called(walletID, amount, currency, ttl, ctx)
+	return args.Get(0).(*Hold), args.Error(1)
+}
+
+func (m *MockRepository) CaptureHold(walletID, holdID string, amount int64, ctx context.Context) (*Hold, error) {
+	args := m.Called(walletID, holdID, amount, ctx)
+	return args.Get(0).(*Hold), args.Error(1)
+}
+
+func (m *MockRepository) VoidHold(walletID, holdID string, ctx context.Context) (*Hold, error) {
+	args := m.Called(walletID, holdID, ctx)
+	return args.Get(0).(*Hold), args.Error(1)
+}
+
+func (m *MockRepository) ExpireHolds(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockRepository) GetTransactions(walletID string, filter TransactionFilter, ctx context.Context) ([]Transaction, error) {
+	args := m.Called(walletID, filter, ctx)
+	return args.Get(0).([]Transaction), args.Error(1)
+}
+
+func (m *MockRepository) ReserveIdempotencyKey(principal, key, requestHash string, ttl, lease time.Duration, ctx context.Context) (*IdempotentResponse, error) {
+	args := m.Called(principal, key, requestHash, ttl, lease, ctx)
+	return args.Get(0).(*IdempotentResponse), args.Error(1)
+}
+
+func (m *MockRepository) SaveIdempotentResponse(principal, key string, response IdempotentResponse, ctx context.Context) error {
+	args := m.Called(principal, key, response, ctx)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ReleaseIdempotencyKey(principal, key string, ctx context.Context) error {
+	args := m.Called(principal, key, ctx)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) GetAPIClient(keyHash string, ctx context.Context) (*APIClient, error) {
+	args := m.Called(keyHash, ctx)
+	client, _ := args.Get(0).(*APIClient)
+	return client, args.Error(1)
+}
+
+func (m *MockRepository) UseNonce(clientID, nonce string, ttl time.Duration, ctx context.Context) error {
+	return m.Called(clientID, nonce, ttl, ctx).Error(0)
+}
+
+func (m *MockRepository) PurgeNonces(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) Ping(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+func (m *MockRepository) SchemaVersion(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) PoolStats() PoolStats {
+	return m.Called().Get(0).(PoolStats)
+}
+
 func (m *MockRepository) Close() {}
 
 type MockLogger struct {
@@ -57,77 +170,317 @@ func (m *MockLogger) FatalCtx(ctx context.Context, msg string, err error) {
 func (m *MockLogger) WarnCtx(ctx context.Context, msg string) {
 	m.Called(ctx, msg)
 }
+func (m *MockLogger) SetLevel(level string) error {
+	args := m.Called(level)
+	return args.Error(0)
+}
+func (m *MockLogger) Level() string {
+	args := m.Called()
+	return args.String(0)
+}
+func (m *MockLogger) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockLogger) With(keysAndValues ...any) logger.Logger {
+	args := m.Called(keysAndValues...)
+	return args.Get(0).(logger.Logger)
+}
 
 func TestHandleWalletOperation(t *testing.T) {
 	mockLogger := new(MockLogger)
 	mockRepo := new(MockRepository)
-	handler := &Handler{repo: mockRepo, lg: mockLogger}
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: defaultMaxOperationAmount}
 
 	tests := []struct {
 		name           string
 		requestBody    WalletOperationRequest
 		expectedStatus int
+		expectedCode   string
 		mockRepoFunc   func()
 		mockLoggerFunc func()
 	}{
 		{
 			name: "Successful Deposit",
 			requestBody: WalletOperationRequest{
-				WalletID:      "123",
+				WalletID:      walletA,
 				OperationType: DEPOSIT,
 				Amount:        100,
+				Currency:      "RUB",
+			},
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("Deposit", walletA, int64(100), "RUB", mock.Anything).Return(nil)
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "operationType", DEPOSIT, "amount", int64(100)).Return(mockLogger)
+				mockLogger.On("InfoCtx", mock.Anything, "wallet operation succeeded").Return()
+			},
+		},
+		{
+			name: "URN Wallet ID",
+			requestBody: WalletOperationRequest{
+				WalletID:      "urn:uuid:" + strings.ToUpper(walletA),
+				OperationType: DEPOSIT,
+				Amount:        300,
+				Currency:      "RUB",
 			},
 			expectedStatus: http.StatusOK,
 			mockRepoFunc: func() {
-				mockRepo.On("Deposit", "123", int64(100), mock.Anything).Return(nil)
+				mockRepo.On("Deposit", walletA, int64(300), "RUB", mock.Anything).Return(nil).Once()
 			},
 			mockLoggerFunc: func() {
-				mockLogger.On("InfoCtx", mock.Anything, "wallet id = 123, operation = DEPOSIT , amount = 100 is success").Return()
+				mockLogger.On("With", "walletId", walletA, "operationType", DEPOSIT, "amount", int64(300)).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "wallet operation succeeded").Return()
 			},
 		},
 		{
 			name: "Deposit Error",
 			requestBody: WalletOperationRequest{
-				WalletID:      "1234",
+				WalletID:      walletB,
 				OperationType: DEPOSIT,
 				Amount:        100,
+				Currency:      "RUB",
 			},
 			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.WalletNotFound,
+			mockRepoFunc: func() {
+				mockRepo.On("Deposit", walletB, int64(100), "RUB", mock.Anything).Return(errWalletid)
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletB, "operationType", DEPOSIT, "amount", int64(100)).Return(mockLogger)
+				mockLogger.On("With", "error", errWalletid).Return(mockLogger)
+				mockLogger.On("ErrorCtx", mock.Anything, "wallet operation failed").Return()
+			},
+		},
+		{
+			name: "Withdraw Insufficient Funds",
+			requestBody: WalletOperationRequest{
+				WalletID:      walletA,
+				OperationType: WITHDRAW,
+				Amount:        500,
+				Currency:      "RUB",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.InsufficientFunds,
+			mockRepoFunc: func() {
+				mockRepo.On("Withdraw", walletA, int64(500), "RUB", mock.Anything).Return(errInsufficientFunds)
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "operationType", WITHDRAW, "amount", int64(500)).Return(mockLogger)
+				mockLogger.On("With", "error", errInsufficientFunds).Return(mockLogger)
+				mockLogger.On("ErrorCtx", mock.Anything, "wallet operation failed").Return()
+			},
+		},
+		{
+			name: "Withdraw Database Error",
+			requestBody: WalletOperationRequest{
+				WalletID:      walletA,
+				OperationType: WITHDRAW,
+				Amount:        600,
+				Currency:      "RUB",
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.InternalError,
+			mockRepoFunc: func() {
+				mockRepo.On("Withdraw", walletA, int64(600), "RUB", mock.Anything).Return(errors.New("db error"))
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "operationType", WITHDRAW, "amount", int64(600)).Return(mockLogger)
+				mockLogger.On("With", "error", errors.New("db error")).Return(mockLogger)
+				mockLogger.On("ErrorCtx", mock.Anything, "wallet operation failed").Return()
+			},
+		},
+		{
+			name: "Withdraw Timeout",
+			requestBody: WalletOperationRequest{
+				WalletID:      walletA,
+				OperationType: WITHDRAW,
+				Amount:        700,
+				Currency:      "RUB",
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.ServiceUnavailable,
+			mockRepoFunc: func() {
+				mockRepo.On("Withdraw", walletA, int64(700), "RUB", mock.Anything).Return(fmt.Errorf("func withdraw: %w", context.DeadlineExceeded))
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "operationType", WITHDRAW, "amount", int64(700)).Return(mockLogger)
+				mockLogger.On("With", "error", fmt.Errorf("func withdraw: %w", context.DeadlineExceeded)).Return(mockLogger)
+				mockLogger.On("ErrorCtx", mock.Anything, "wallet operation failed").Return()
+			},
+		},
+		{
+			name: "Successful Transfer",
+			requestBody: WalletOperationRequest{
+				WalletID:       walletA,
+				OperationType:  TRANSFER,
+				Amount:         100,
+				Currency:       "RUB",
+				TargetWalletID: walletC,
+			},
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("Transfer", walletA, walletC, int64(100), "RUB", mock.Anything).Return(nil)
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "operationType", TRANSFER, "amount", int64(100), "targetWalletId", walletC).Return(mockLogger)
+				mockLogger.On("InfoCtx", mock.Anything, "wallet operation succeeded").Return()
+			},
+		},
+		{
+			name: "Withdraw In Another Currency",
+			requestBody: WalletOperationRequest{
+				WalletID:      walletB,
+				OperationType: WITHDRAW,
+				Amount:        100,
+				Currency:      "EUR",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.CurrencyMismatch,
 			mockRepoFunc: func() {
-				mockRepo.On("Deposit", "1234", int64(100), mock.Anything).Return(errWalletid)
+				mockRepo.On("Withdraw", walletB, int64(100), "EUR", mock.Anything).Return(errCurrencyMismatch)
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletB, "operationType", WITHDRAW, "amount", int64(100)).Return(mockLogger)
+				mockLogger.On("With", "error", errCurrencyMismatch).Return(mockLogger)
+				mockLogger.On("ErrorCtx", mock.Anything, "wallet operation failed").Return()
+			},
+		},
+		{
+			name: "Transfer To Same Wallet",
+			requestBody: WalletOperationRequest{
+				WalletID:       walletA,
+				OperationType:  TRANSFER,
+				Amount:         100,
+				Currency:       "RUB",
+				TargetWalletID: walletA,
 			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockRepoFunc:   func() {},
 			mockLoggerFunc: func() {
-				mockLogger.On("ErrorCtx", mock.Anything, "insufficient funds or walletid not found").Return()
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{targetWalletId must differ from walletId}]").Return()
 			},
 		},
 		{
 			name: "Invalid Operation Type",
 			requestBody: WalletOperationRequest{
-				WalletID:      "123",
+				WalletID:      walletA,
 				OperationType: "INVALID",
 				Amount:        100,
+				Currency:      "RUB",
 			},
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidOperation,
 			mockRepoFunc:   func() {},
 			mockLoggerFunc: func() {
-				mockLogger.On("ErrorCtx", mock.Anything, "invalid operation type").Return()
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{operationType must be one of DEPOSIT, WITHDRAW, TRANSFER}]").Return()
 			},
 		},
 	}
 
+	rawTests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		mockLoggerFunc func()
+	}{
+		{
+			name:           "Negative Deposit",
+			body:           `{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":-100,"currency":"RUB"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{amount must be positive}]").Return().Once()
+			},
+		},
+		{
+			name:           "Every Field Invalid",
+			body:           `{"walletId":"not-a-uuid","operationType":"TRANSFER","amount":2000000000,"currency":"RUB"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{walletId must be a valid UUID} {targetWalletId must be a valid UUID} {amount must not exceed 1000000000}]").Return().Once()
+			},
+		},
+		{
+			name:           "Unsupported Currency",
+			body:           `{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"usd"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{currency must be a supported ISO 4217 currency code}]").Return().Once()
+			},
+		},
+		{
+			name:           "Missing Currency",
+			body:           `{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   problem.ValidationFailed,
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{currency is required}]").Return().Once()
+			},
+		},
+		{
+			name:           "Unknown Field",
+			body:           `{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB","comment":"salary"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidRequest,
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "error decode request body").Return().Once()
+			},
+		},
+		{
+			name:           "Oversized Body",
+			body:           `{"walletId":"` + strings.Repeat("a", maxOperationBodySize) + `"}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedCode:   problem.RequestTooLarge,
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "error decode request body").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range rawTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockLoggerFunc()
+
+			req := httptest.NewRequest(http.MethodPost, "/wallet/operation", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.HandleWalletOperation(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			var body problem.Problem
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Equal(t, tt.expectedCode, body.Code)
+
+			mockLogger.AssertExpectations(t)
+		})
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mockRepoFunc()
 			tt.mockLoggerFunc()
 
 			body, _ := json.Marshal(tt.requestBody)
-			req := httptest.NewRequest(http.MethodPost, "/wallet/operation", bytes.NewBuffer(body))
+			req := withClient(httptest.NewRequest(http.MethodPost, "/wallet/operation", bytes.NewBuffer(body)), adminClient)
 			w := httptest.NewRecorder()
 
 			handler.HandleWalletOperation(w, req)
 
 			res := w.Result()
 			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedCode != "" {
+				var body problem.Problem
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, problem.ContentType, res.Header.Get("Content-Type"))
+				assert.Equal(t, tt.expectedCode, body.Code)
+			}
 
 			mockRepo.AssertExpectations(t)
 			mockLogger.AssertExpectations(t)
@@ -152,37 +505,41 @@ func TestGetWalletBalance(t *testing.T) {
 	}{
 		{
 			name:           "Successful Get Balance",
-			walletID:       "123",
+			walletID:       walletA,
 			expectedStatus: http.StatusOK,
 			mockRepoFunc: func() {
-				mockRepo.On("GetBalance", "123", mock.Anything).Return(int64(1000), nil)
+				mockRepo.On("GetBalance", walletA, mock.Anything).Return(Balance{Ledger: 1000, Available: 700}, nil)
 			},
 			mockLoggerFunc: func() {
-				mockLogger.On("DebugCtx", mock.Anything, "walletId=123").Return()
-				mockLogger.On("InfoCtx", mock.Anything, "wallet id = 123, balance = 1000 is success").Return()
+				mockLogger.On("With", "walletId", walletA).Return(mockLogger)
+				mockLogger.On("DebugCtx", mock.Anything, "getting balance").Return()
+				mockLogger.On("With", "ledger", int64(1000), "available", int64(700)).Return(mockLogger)
+				mockLogger.On("InfoCtx", mock.Anything, "balance retrieved").Return()
 			},
 		},
 		{
 			name:           "Wallet Not Found",
-			walletID:       "1234",
+			walletID:       walletB,
 			expectedStatus: http.StatusNotFound,
 			mockRepoFunc: func() {
-				mockRepo.On("GetBalance", "1234", mock.Anything).Return(int64(0), errWalletid)
+				mockRepo.On("GetBalance", walletB, mock.Anything).Return(Balance{}, errWalletid)
 			},
 			mockLoggerFunc: func() {
-				mockLogger.On("DebugCtx", mock.Anything, "walletId=1234").Return()
+				mockLogger.On("With", "walletId", walletB).Return(mockLogger)
+				mockLogger.On("DebugCtx", mock.Anything, "getting balance").Return()
 				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return()
 			},
 		},
 		{
 			name:           "Error Getting Balance",
-			walletID:       "12345",
+			walletID:       walletC,
 			expectedStatus: http.StatusInternalServerError,
 			mockRepoFunc: func() {
-				mockRepo.On("GetBalance", "12345", mock.Anything).Return(int64(0), errors.New("some error"))
+				mockRepo.On("GetBalance", walletC, mock.Anything).Return(Balance{}, errors.New("some error"))
 			},
 			mockLoggerFunc: func() {
-				mockLogger.On("DebugCtx", mock.Anything, "walletId=12345").Return()
+				mockLogger.On("With", "walletId", walletC).Return(mockLogger)
+				mockLogger.On("DebugCtx", mock.Anything, "getting balance").Return()
 				mockLogger.On("ErrorCtx", mock.Anything, "error getting balance").Return()
 			},
 		},
@@ -205,3 +562,119 @@ func TestGetWalletBalance(t *testing.T) {
 		})
 	}
 }
+
+func TestGetWalletTransactions(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger}
+
+	r := chi.NewRouter()
+	r.Get("/wallet/{id}/transactions", handler.GetWalletTransactions)
+
+	page := []Transaction{
+		{ID: 9, WalletID: walletA, OperationType: DEPOSIT, Amount: 10, BalanceAfter: 30},
+		{ID: 8, WalletID: walletA, OperationType: DEPOSIT, Amount: 10, BalanceAfter: 20},
+		{ID: 7, WalletID: walletA, OperationType: DEPOSIT, Amount: 10, BalanceAfter: 10},
+	}
+
+	tests := []struct {
+		name               string
+		url                string
+		expectedStatus     int
+		expectedNextCursor string
+		expectedCount      int
+		mockRepoFunc       func()
+		mockLoggerFunc     func()
+	}{
+		{
+			name:               "First Page With More Results",
+			url:                "/wallet/" + walletA + "/transactions?limit=2&operationType=DEPOSIT",
+			expectedStatus:     http.StatusOK,
+			expectedNextCursor: encodeCursor(8),
+			expectedCount:      2,
+			mockRepoFunc: func() {
+				mockRepo.On("GetTransactions", walletA, TransactionFilter{OperationType: DEPOSIT, Limit: 3}, mock.Anything).Return(page, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "getting transactions").Return().Once()
+				mockLogger.On("With", "transactions", 2).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "transactions retrieved").Return().Once()
+			},
+		},
+		{
+			name:               "Last Page",
+			url:                "/wallet/" + walletA + "/transactions?limit=5&cursor=" + encodeCursor(10),
+			expectedStatus:     http.StatusOK,
+			expectedNextCursor: "",
+			expectedCount:      3,
+			mockRepoFunc: func() {
+				mockRepo.On("GetTransactions", walletA, TransactionFilter{Cursor: 10, Limit: 6}, mock.Anything).Return(page, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "getting transactions").Return().Once()
+				mockLogger.On("With", "transactions", 3).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "transactions retrieved").Return().Once()
+			},
+		},
+		{
+			name:           "Invalid Amount Range",
+			url:            "/wallet/" + walletA + "/transactions?minAmount=10&maxAmount=5",
+			expectedStatus: http.StatusBadRequest,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "getting transactions").Return().Once()
+				mockLogger.On("With", "error", errors.New("minAmount must not exceed maxAmount")).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid transactions filter").Return().Once()
+			},
+		},
+		{
+			name:           "Malformed Wallet ID",
+			url:            "/wallet/not-a-uuid/transactions",
+			expectedStatus: http.StatusNotFound,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return().Once()
+			},
+		},
+		{
+			name:           "Wallet Not Found",
+			url:            "/wallet/" + walletB + "/transactions",
+			expectedStatus: http.StatusNotFound,
+			mockRepoFunc: func() {
+				mockRepo.On("GetTransactions", walletB, TransactionFilter{Limit: defaultTransactionsLimit + 1}, mock.Anything).Return([]Transaction(nil), errWalletid).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletB).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "getting transactions").Return().Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+
+			if tt.expectedStatus == http.StatusOK {
+				var body TransactionsPage
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				assert.Equal(t, tt.expectedNextCursor, body.NextCursor)
+				assert.Len(t, body.Transactions, tt.expectedCount)
+			}
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
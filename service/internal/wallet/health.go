@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// expectedSchemaVersion is the goose version of the newest file in migrations/.
+// The service is not ready until the database has been migrated at least this far.
+const expectedSchemaVersion int64 = 20250320110000
+
+const (
+	healthCheckTimeout = 2 * time.Second
+
+	checkOK        = "ok"
+	checkFailed    = "failed"
+	checkSaturated = "saturated"
+)
+
+// PoolStats is a snapshot of the database connection pool.
+type PoolStats struct {
+	Acquired int32 `json:"acquired"`
+	Idle     int32 `json:"idle"`
+	Total    int32 `json:"total"`
+	Max      int32 `json:"max"`
+}
+
+// Saturation is the share of the pool that is in use, between 0 and 1.
+func (s PoolStats) Saturation() float64 {
+	if s.Max <= 0 {
+		return 0
+	}
+	return float64(s.Acquired) / float64(s.Max)
+}
+
+type HealthCheck struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	LatencyMs  float64 `json:"latencyMs,omitempty"`
+	Version    int64   `json:"version,omitempty"`
+	Expected   int64   `json:"expected,omitempty"`
+	Saturation float64 `json:"saturation,omitempty"`
+	*PoolStats
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is alive. It never touches the database, so a
+// database outage does not get the service restarted.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthReport{Status: checkOK})
+}
+
+// Readyz reports whether the service can serve traffic: Postgres answers and is
+// migrated to expectedSchemaVersion. Pool saturation is reported but does not fail
+// the check, so a busy instance is not taken out of rotation on top of its load.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]HealthCheck{
+		"database":   h.checkDatabase(ctx),
+		"migrations": h.checkMigrations(ctx),
+		"pool":       h.checkPool(),
+	}
+
+	var failed []string
+	for name, check := range checks {
+		if check.Status == checkFailed {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		h.lg.ErrorCtx(r.Context(), fmt.Sprintf("service is not ready: %s", strings.Join(failed, ", ")))
+		writeHealth(w, http.StatusServiceUnavailable, HealthReport{Status: checkFailed, Checks: checks})
+		return
+	}
+	writeHealth(w, http.StatusOK, HealthReport{Status: checkOK, Checks: checks})
+}
+
+func (h *Handler) checkDatabase(ctx context.Context) HealthCheck {
+	start := time.Now()
+	if err := h.repo.Ping(ctx); err != nil {
+		return HealthCheck{Status: checkFailed, Error: err.Error()}
+	}
+	return HealthCheck{Status: checkOK, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+}
+
+func (h *Handler) checkMigrations(ctx context.Context) HealthCheck {
+	version, err := h.repo.SchemaVersion(ctx)
+	if err != nil {
+		return HealthCheck{Status: checkFailed, Error: err.Error(), Expected: expectedSchemaVersion}
+	}
+	check := HealthCheck{Status: checkOK, Version: version, Expected: expectedSchemaVersion}
+	if version < expectedSchemaVersion {
+		check.Status = checkFailed
+		check.Error = "database schema is behind the service"
+	}
+	return check
+}
+
+func (h *Handler) checkPool() HealthCheck {
+	stats := h.repo.PoolStats()
+	check := HealthCheck{Status: checkOK, Saturation: stats.Saturation(), PoolStats: &stats}
+	if stats.Max > 0 && stats.Acquired >= stats.Max {
+		check.Status = checkSaturated
+	}
+	return check
+}
+
+func writeHealth(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.db.Ping(ctx)
+}
+
+// SchemaVersion returns the goose version the database was last migrated to. Rows of
+// rolled-back migrations are ignored.
+func (r *Repository) SchemaVersion(ctx context.Context) (int64, error) {
+	var version int64
+	err := r.db.QueryRow(ctx, "SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1").Scan(&version)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *Repository) PoolStats() PoolStats {
+	stat := r.db.Stat()
+	return PoolStats{
+		Acquired: stat.AcquiredConns(),
+		Idle:     stat.IdleConns(),
+		Total:    stat.TotalConns(),
+		Max:      stat.MaxConns(),
+	}
+}
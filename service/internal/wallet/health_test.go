@@ -0,0 +1,109 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name             string
+		pingErr          error
+		version          int64
+		versionErr       error
+		pool             PoolStats
+		expectedStatus   int
+		expectedChecks   map[string]string
+		expectedErrorLog string
+	}{
+		{
+			name:           "Ready",
+			version:        expectedSchemaVersion,
+			pool:           PoolStats{Acquired: 5, Idle: 5, Total: 10, Max: 20},
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"database": checkOK, "migrations": checkOK, "pool": checkOK},
+		},
+		{
+			name:           "Saturated Pool Stays Ready",
+			version:        expectedSchemaVersion + 1,
+			pool:           PoolStats{Acquired: 20, Total: 20, Max: 20},
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"database": checkOK, "migrations": checkOK, "pool": checkSaturated},
+		},
+		{
+			name:             "Schema Behind",
+			version:          20250101000000,
+			pool:             PoolStats{Max: 20},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedChecks:   map[string]string{"database": checkOK, "migrations": checkFailed, "pool": checkOK},
+			expectedErrorLog: "service is not ready: migrations",
+		},
+		{
+			name:             "Database Down",
+			pingErr:          errors.New("connection refused"),
+			versionErr:       errors.New("connection refused"),
+			pool:             PoolStats{Max: 20},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedChecks:   map[string]string{"database": checkFailed, "migrations": checkFailed, "pool": checkOK},
+			expectedErrorLog: "service is not ready: database, migrations",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockLogger := new(MockLogger)
+			mockRepo := new(MockRepository)
+			handler := &Handler{repo: mockRepo, lg: mockLogger}
+
+			mockRepo.On("Ping", mock.Anything).Return(tt.pingErr).Once()
+			mockRepo.On("SchemaVersion", mock.Anything).Return(tt.version, tt.versionErr).Once()
+			mockRepo.On("PoolStats").Return(tt.pool).Once()
+			if tt.expectedErrorLog != "" {
+				mockLogger.On("ErrorCtx", mock.Anything, tt.expectedErrorLog).Return().Once()
+			}
+
+			w := httptest.NewRecorder()
+			handler.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			var report HealthReport
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+			checks := make(map[string]string)
+			for name, check := range report.Checks {
+				checks[name] = check.Status
+			}
+			assert.Equal(t, tt.expectedChecks, checks)
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_SchemaVersion(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const query = "SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1"
+	mockPool.On("QueryRow", mock.Anything, query).Return(&mockRow{values: []any{expectedSchemaVersion}}).Once()
+	version, err := repo.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expectedSchemaVersion, version)
+
+	mockPool.On("QueryRow", mock.Anything, query).Return(&mockRow{err: pgx.ErrNoRows}).Once()
+	version, err = repo.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+
+	mockPool.AssertExpectations(t)
+}
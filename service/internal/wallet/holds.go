@@ -0,0 +1,354 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"service/internal/problem"
+	"service/internal/requestctx"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	guid "github.com/satori/go.uuid"
+)
+
+// Hold statuses. An ACTIVE hold reserves money until it is captured, voided or expires.
+const (
+	CAPTURED string = "CAPTURED"
+	VOIDED   string = "VOIDED"
+	EXPIRED  string = "EXPIRED"
+)
+
+const (
+	defaultHoldTTL    = 7 * 24 * time.Hour
+	maxHoldTTL        = 30 * 24 * time.Hour
+	holdExpiryPeriod  = time.Minute
+	holdColumnsSelect = "id::text, wallet_id::text, amount, captured_amount, CASE WHEN status = 'ACTIVE' AND expires_at <= now() THEN 'EXPIRED' ELSE status END, expires_at, created_at"
+)
+
+var (
+	errHoldNotFound       = errors.New("hold not found")
+	errHoldNotActive      = errors.New("hold is already captured, voided or expired")
+	errCaptureExceedsHold = errors.New("capture amount exceeds the held amount")
+)
+
+// Hold reserves part of a wallet's balance until it is captured or released.
+type Hold struct {
+	ID             string    `json:"id"`
+	WalletID       string    `json:"walletId"`
+	Amount         int64     `json:"amount"`
+	CapturedAmount int64     `json:"capturedAmount"`
+	Status         string    `json:"status"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type CreateHoldRequest struct {
+	Amount     int64  `json:"amount"`
+	Currency   string `json:"currency"`
+	TTLSeconds int64  `json:"ttlSeconds"`
+}
+
+func (req CreateHoldRequest) Validate(maxAmount int64) []problem.FieldError {
+	var errs []problem.FieldError
+	if req.Amount <= 0 {
+		errs = append(errs, problem.FieldError{Field: "amount", Message: "must be positive"})
+	} else if limit := maxAmountIn(req.Currency, maxAmount); limit > 0 && validCurrency(req.Currency) && req.Amount > limit {
+		errs = append(errs, problem.FieldError{Field: "amount", Message: fmt.Sprintf("must not exceed %d", limit)})
+	}
+	if req.Currency == "" {
+		errs = append(errs, problem.FieldError{Field: "currency", Message: "is required"})
+	} else if !validCurrency(req.Currency) {
+		errs = append(errs, problem.FieldError{Field: "currency", Message: "must be a supported ISO 4217 currency code"})
+	}
+	if req.TTLSeconds < 0 || time.Duration(req.TTLSeconds)*time.Second > maxHoldTTL {
+		errs = append(errs, problem.FieldError{Field: "ttlSeconds", Message: fmt.Sprintf("must be between 0 and %d", int64(maxHoldTTL/time.Second))})
+	}
+	return errs
+}
+
+// CaptureHoldRequest captures Amount of a hold; zero captures the whole hold.
+type CaptureHoldRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+func (req CaptureHoldRequest) Validate() []problem.FieldError {
+	if req.Amount < 0 {
+		return []problem.FieldError{{Field: "amount", Message: "must not be negative"}}
+	}
+	return nil
+}
+
+func (h *Handler) CreateHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	walletID, ok := h.walletParam(w, r)
+	if !ok {
+		return
+	}
+
+	var request CreateHoldRequest
+	if err := decodeJSON(w, r, maxOperationBodySize, &request); err != nil {
+		h.lg.ErrorCtx(ctx, "error decode request body")
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := request.Validate(h.maxAmount); len(errs) > 0 {
+		h.lg.ErrorCtx(ctx, fmt.Sprintf("invalid request: %v", errs))
+		problem.WriteValidation(w, r, errs)
+		return
+	}
+	ttl := time.Duration(request.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = defaultHoldTTL
+	}
+
+	hold, err := h.repo.CreateHold(walletID, request.Amount, request.Currency, ttl, ctx)
+	lg := h.lg.With("walletId", walletID, "amount", request.Amount)
+	if err != nil {
+		lg.With("error", err).ErrorCtx(ctx, "create hold failed")
+		writeError(w, r, err)
+		return
+	}
+
+	writeHold(w, http.StatusCreated, hold)
+	lg.With("holdId", hold.ID).InfoCtx(ctx, "hold created")
+}
+
+func (h *Handler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	walletID, holdID, ok := h.holdParams(w, r)
+	if !ok {
+		return
+	}
+
+	var request CaptureHoldRequest
+	// An empty body captures the full hold.
+	if err := decodeJSON(w, r, maxOperationBodySize, &request); err != nil && err != io.EOF {
+		h.lg.ErrorCtx(ctx, "error decode request body")
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := request.Validate(); len(errs) > 0 {
+		h.lg.ErrorCtx(ctx, fmt.Sprintf("invalid request: %v", errs))
+		problem.WriteValidation(w, r, errs)
+		return
+	}
+
+	hold, err := h.repo.CaptureHold(walletID, holdID, request.Amount, ctx)
+	lg := h.lg.With("walletId", walletID, "holdId", holdID)
+	if err != nil {
+		lg.With("error", err).ErrorCtx(ctx, "capture hold failed")
+		writeError(w, r, err)
+		return
+	}
+
+	writeHold(w, http.StatusOK, hold)
+	lg.With("capturedAmount", hold.CapturedAmount).InfoCtx(ctx, "hold captured")
+}
+
+func (h *Handler) VoidHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	walletID, holdID, ok := h.holdParams(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := h.repo.VoidHold(walletID, holdID, ctx)
+	lg := h.lg.With("walletId", walletID, "holdId", holdID)
+	if err != nil {
+		lg.With("error", err).ErrorCtx(ctx, "void hold failed")
+		writeError(w, r, err)
+		return
+	}
+
+	writeHold(w, http.StatusOK, hold)
+	lg.InfoCtx(ctx, "hold voided")
+}
+
+// holdParams reads the wallet and hold IDs from the route in canonical form.
+// Malformed IDs cannot match any row, so they are reported as not found.
+func (h *Handler) holdParams(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	walletID, ok := h.walletParam(w, r)
+	if !ok {
+		return "", "", false
+	}
+	holdID, err := guid.FromString(chi.URLParam(r, "holdId"))
+	if err != nil {
+		h.lg.ErrorCtx(r.Context(), "hold not found")
+		writeError(w, r, errHoldNotFound)
+		return "", "", false
+	}
+	return walletID, holdID.String(), true
+}
+
+func writeHold(w http.ResponseWriter, status int, hold *Hold) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(hold)
+}
+
+// expireHolds marks lapsed holds as EXPIRED until the handler is closed. Expired
+// holds stop reserving money as soon as they lapse; this only tidies their status.
+func (h *Handler) expireHolds(ctx context.Context) {
+	ticker := time.NewTicker(holdExpiryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			expired, err := h.repo.ExpireHolds(ctx)
+			if err != nil {
+				h.lg.ErrorCtx(ctx, "error expiring holds")
+				continue
+			}
+			h.lg.DebugCtx(ctx, fmt.Sprintf("expired holds = %d", expired))
+		}
+	}
+}
+
+func scanHold(row pgx.Row) (*Hold, error) {
+	hold := new(Hold)
+	err := row.Scan(&hold.ID, &hold.WalletID, &hold.Amount, &hold.CapturedAmount, &hold.Status, &hold.ExpiresAt, &hold.CreatedAt)
+	return hold, err
+}
+
+// CreateHold reserves amount of the wallet's available balance for ttl.
+func (r *Repository) CreateHold(walletID string, amount int64, currency string, ttl time.Duration, ctx context.Context) (*Hold, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func createhold begin transaction failed")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	available, err := r.lockWallet(tx, walletID, currency, ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, fmt.Sprintf("func createhold lock wallet failed: %v", err))
+		return nil, err
+	}
+	if available < amount {
+		r.lg.ErrorCtx(ctx, "func createhold insufficient funds")
+		return nil, errInsufficientFunds
+	}
+
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	userID, _ := requestctx.UserIDFromContext(ctx)
+	hold, err := scanHold(tx.QueryRow(ctx, "INSERT INTO wallet_holds (wallet_id, amount, expires_at, client_id, user_id) VALUES ($1, $2, now() + make_interval(secs => $3), NULLIF($4, '')::uuid, NULLIF($5, '')) RETURNING "+holdColumnsSelect,
+		walletID, amount, ttl.Seconds(), clientID, userID))
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func createhold sql query failed")
+		return nil, err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func createhold commit failed")
+		return nil, err
+	}
+	return hold, nil
+}
+
+// CaptureHold charges amount of an active hold (the whole hold when amount is zero)
+// and releases the rest of it.
+func (r *Repository) CaptureHold(walletID, holdID string, amount int64, ctx context.Context) (*Hold, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func capturehold begin transaction failed")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The wallet is locked before the hold, in the same order CreateHold uses.
+	if _, err := r.lockWallet(tx, walletID, "", ctx); err != nil {
+		r.lg.ErrorCtx(ctx, fmt.Sprintf("func capturehold lock wallet failed: %v", err))
+		return nil, err
+	}
+	hold, err := r.lockHold(tx, walletID, holdID, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if amount == 0 {
+		amount = hold.Amount
+	}
+	if amount > hold.Amount {
+		return nil, errCaptureExceedsHold
+	}
+
+	var balance int64
+	if err := tx.QueryRow(ctx, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 RETURNING balance", amount, walletID).Scan(&balance); err != nil {
+		r.lg.ErrorCtx(ctx, "func capturehold sql query failed")
+		return nil, err
+	}
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	userID, _ := requestctx.UserIDFromContext(ctx)
+	hold, err = scanHold(tx.QueryRow(ctx, "UPDATE wallet_holds SET status = $1, captured_amount = $2, updated_at = now(), updated_by_client_id = NULLIF($4, '')::uuid, updated_by_user_id = NULLIF($5, '') WHERE id = $3 RETURNING "+holdColumnsSelect,
+		CAPTURED, amount, holdID, clientID, userID))
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func capturehold sql query failed")
+		return nil, err
+	}
+	if err := r.recordTransaction(tx, Transaction{WalletID: walletID, OperationType: CAPTURE, Amount: amount, BalanceAfter: balance}, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func capturehold record transaction failed")
+		return nil, err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func capturehold commit failed")
+		return nil, err
+	}
+	return hold, nil
+}
+
+// VoidHold releases an active hold without moving any money.
+func (r *Repository) VoidHold(walletID, holdID string, ctx context.Context) (*Hold, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func voidhold begin transaction failed")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := r.lockHold(tx, walletID, holdID, ctx); err != nil {
+		return nil, err
+	}
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	userID, _ := requestctx.UserIDFromContext(ctx)
+	hold, err := scanHold(tx.QueryRow(ctx, "UPDATE wallet_holds SET status = $1, updated_at = now(), updated_by_client_id = NULLIF($3, '')::uuid, updated_by_user_id = NULLIF($4, '') WHERE id = $2 RETURNING "+holdColumnsSelect, VOIDED, holdID, clientID, userID))
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func voidhold sql query failed")
+		return nil, err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func voidhold commit failed")
+		return nil, err
+	}
+	return hold, nil
+}
+
+// lockHold locks a hold of the wallet and makes sure it can still be captured or voided.
+func (r *Repository) lockHold(tx pgx.Tx, walletID, holdID string, ctx context.Context) (*Hold, error) {
+	hold, err := scanHold(tx.QueryRow(ctx, "SELECT "+holdColumnsSelect+" FROM wallet_holds WHERE id = $1 AND wallet_id = $2 FOR UPDATE", holdID, walletID))
+	if err == pgx.ErrNoRows {
+		r.lg.ErrorCtx(ctx, "func lockhold hold not found")
+		return nil, errHoldNotFound
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "Could not scan hold")
+		return nil, err
+	}
+	if hold.Status != ACTIVE {
+		r.lg.ErrorCtx(ctx, fmt.Sprintf("func lockhold hold is %s", hold.Status))
+		return nil, errHoldNotActive
+	}
+	return hold, nil
+}
+
+func (r *Repository) ExpireHolds(ctx context.Context) (int64, error) {
+	result, err := r.db.Exec(ctx, "UPDATE wallet_holds SET status = $1, updated_at = now() WHERE status = 'ACTIVE' AND expires_at <= now()", EXPIRED)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func expireholds sql query failed")
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,334 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/requestctx"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const holdA = "6a0c1f0e-2b8d-4d53-a1f4-9c1e6f2d7b40"
+
+func TestHoldHandlers(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, maxAmount: 1000}
+
+	r := chi.NewRouter()
+	r.Post("/wallet/{id}/holds", handler.CreateHold)
+	r.Post("/wallet/{id}/holds/{holdId}/capture", handler.CaptureHold)
+	r.Post("/wallet/{id}/holds/{holdId}/void", handler.VoidHold)
+
+	hold := &Hold{ID: holdA, WalletID: walletA, Amount: 100, Status: ACTIVE, CreatedAt: time.Now()}
+	captured := &Hold{ID: holdA, WalletID: walletA, Amount: 100, CapturedAmount: 40, Status: CAPTURED, CreatedAt: time.Now()}
+
+	tests := []struct {
+		name           string
+		url            string
+		body           string
+		expectedStatus int
+		mockRepoFunc   func()
+		mockLoggerFunc func()
+	}{
+		{
+			name:           "Create Hold",
+			url:            "/wallet/" + walletA + "/holds",
+			body:           `{"amount":100,"currency":"RUB","ttlSeconds":60}`,
+			expectedStatus: http.StatusCreated,
+			mockRepoFunc: func() {
+				mockRepo.On("CreateHold", walletA, int64(100), "RUB", time.Minute, mock.Anything).Return(hold, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "amount", int64(100)).Return(mockLogger).Once()
+				mockLogger.On("With", "holdId", holdA).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "hold created").Return().Once()
+			},
+		},
+		{
+			name:           "Create Hold With Default TTL",
+			url:            "/wallet/" + walletA + "/holds",
+			body:           `{"amount":100,"currency":"RUB"}`,
+			expectedStatus: http.StatusCreated,
+			mockRepoFunc: func() {
+				mockRepo.On("CreateHold", walletA, int64(100), "RUB", defaultHoldTTL, mock.Anything).Return(hold, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "amount", int64(100)).Return(mockLogger).Once()
+				mockLogger.On("With", "holdId", holdA).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "hold created").Return().Once()
+			},
+		},
+		{
+			name:           "Create Hold Insufficient Funds",
+			url:            "/wallet/" + walletA + "/holds",
+			body:           `{"amount":500,"currency":"RUB"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			mockRepoFunc: func() {
+				mockRepo.On("CreateHold", walletA, int64(500), "RUB", defaultHoldTTL, mock.Anything).Return((*Hold)(nil), errInsufficientFunds).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "amount", int64(500)).Return(mockLogger).Once()
+				mockLogger.On("With", "error", errInsufficientFunds).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "create hold failed").Return().Once()
+			},
+		},
+		{
+			name:           "Create Hold Invalid Amount",
+			url:            "/wallet/" + walletA + "/holds",
+			body:           `{"amount":5000,"currency":"JPY","ttlSeconds":-1}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request: [{amount must not exceed 1000} {ttlSeconds must be between 0 and 2592000}]").Return().Once()
+			},
+		},
+		{
+			name:           "Create Hold Malformed Wallet ID",
+			url:            "/wallet/123/holds",
+			body:           `{"amount":100,"currency":"RUB"}`,
+			expectedStatus: http.StatusNotFound,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return().Once()
+			},
+		},
+		{
+			name:           "Partial Capture",
+			url:            "/wallet/" + walletA + "/holds/" + holdA + "/capture",
+			body:           `{"amount":40}`,
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("CaptureHold", walletA, holdA, int64(40), mock.Anything).Return(captured, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "holdId", holdA).Return(mockLogger).Once()
+				mockLogger.On("With", "capturedAmount", int64(40)).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "hold captured").Return().Once()
+			},
+		},
+		{
+			name:           "Capture More Than Held",
+			url:            "/wallet/" + walletA + "/holds/" + holdA + "/capture",
+			body:           `{"amount":400}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			mockRepoFunc: func() {
+				mockRepo.On("CaptureHold", walletA, holdA, int64(400), mock.Anything).Return((*Hold)(nil), errCaptureExceedsHold).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "holdId", holdA).Return(mockLogger).Once()
+				mockLogger.On("With", "error", errCaptureExceedsHold).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "capture hold failed").Return().Once()
+			},
+		},
+		{
+			name:           "Capture Malformed Hold ID",
+			url:            "/wallet/" + walletA + "/holds/1/capture",
+			expectedStatus: http.StatusNotFound,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "hold not found").Return().Once()
+			},
+		},
+		{
+			name:           "Void Hold With Non-Canonical IDs",
+			url:            "/wallet/" + strings.ToUpper(walletA) + "/holds/urn:uuid:" + holdA + "/void",
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("VoidHold", walletA, holdA, mock.Anything).Return(hold, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "holdId", holdA).Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "hold voided").Return().Once()
+			},
+		},
+		{
+			name:           "Void Expired Hold",
+			url:            "/wallet/" + walletA + "/holds/" + holdA + "/void",
+			expectedStatus: http.StatusConflict,
+			mockRepoFunc: func() {
+				mockRepo.On("VoidHold", walletA, holdA, mock.Anything).Return((*Hold)(nil), errHoldNotActive).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA, "holdId", holdA).Return(mockLogger).Once()
+				mockLogger.On("With", "error", errHoldNotActive).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "void hold failed").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_CaptureHold(t *testing.T) {
+	mockLogger := new(MockLogger)
+
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		lockHoldQuery    = "SELECT " + holdColumnsSelect + " FROM wallet_holds WHERE id = $1 AND wallet_id = $2 FOR UPDATE"
+		debitQuery       = "UPDATE wallets SET balance = balance - $1 WHERE id = $2 RETURNING balance"
+		updateHoldQuery  = "UPDATE wallet_holds SET status = $1, captured_amount = $2, updated_at = now(), updated_by_client_id = NULLIF($4, '')::uuid, updated_by_user_id = NULLIF($5, '') WHERE id = $3 RETURNING " + holdColumnsSelect
+		walletID, holdID = "123", "456"
+	)
+	createdAt := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := createdAt.Add(time.Hour)
+	holdRow := func(status string, captured int64) *mockRow {
+		return &mockRow{values: []any{holdID, walletID, int64(100), captured, status, expiresAt, createdAt}}
+	}
+
+	tests := []struct {
+		name           string
+		amount         int64
+		mockSetup      func(tx *MockTx)
+		mockLoggerFunc func()
+		expectedHold   *Hold
+		expectedErr    error
+	}{
+		{
+			name:   "Partial Capture",
+			amount: 40,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(60, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, lockHoldQuery, holdID, walletID).Return(holdRow(ACTIVE, 0)).Once()
+				tx.On("QueryRow", mock.Anything, debitQuery, int64(40), walletID).Return(newMockRow(int64(120), nil)).Once()
+				tx.On("QueryRow", mock.Anything, updateHoldQuery, CAPTURED, int64(40), holdID, adminClient.ID, "").Return(holdRow(CAPTURED, 40)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, walletID, CAPTURE, int64(40), int64(120), "", "", adminClient.ID, "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedHold:   &Hold{ID: holdID, WalletID: walletID, Amount: 100, CapturedAmount: 40, Status: CAPTURED, ExpiresAt: expiresAt, CreatedAt: createdAt},
+			expectedErr:    nil,
+		},
+		{
+			name:   "Full Capture",
+			amount: 0,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(60, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, lockHoldQuery, holdID, walletID).Return(holdRow(ACTIVE, 0)).Once()
+				tx.On("QueryRow", mock.Anything, debitQuery, int64(100), walletID).Return(newMockRow(int64(60), nil)).Once()
+				tx.On("QueryRow", mock.Anything, updateHoldQuery, CAPTURED, int64(100), holdID, adminClient.ID, "").Return(holdRow(CAPTURED, 100)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, walletID, CAPTURE, int64(100), int64(60), "", "", adminClient.ID, "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedHold:   &Hold{ID: holdID, WalletID: walletID, Amount: 100, CapturedAmount: 100, Status: CAPTURED, ExpiresAt: expiresAt, CreatedAt: createdAt},
+			expectedErr:    nil,
+		},
+		{
+			name:   "Capture Exceeds Hold",
+			amount: 150,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(60, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, lockHoldQuery, holdID, walletID).Return(holdRow(ACTIVE, 0)).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedHold:   nil,
+			expectedErr:    errCaptureExceedsHold,
+		},
+		{
+			name:   "Hold Expired",
+			amount: 40,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(160, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, lockHoldQuery, holdID, walletID).Return(holdRow(EXPIRED, 0)).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func lockhold hold is EXPIRED").Return().Once()
+			},
+			expectedHold: nil,
+			expectedErr:  errHoldNotActive,
+		},
+		{
+			name:   "Hold Not Found",
+			amount: 40,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(60, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, lockHoldQuery, holdID, walletID).Return(&mockRow{err: pgx.ErrNoRows}).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func lockhold hold not found").Return().Once()
+			},
+			expectedHold: nil,
+			expectedErr:  errHoldNotFound,
+		},
+		{
+			name:   "Wallet Closed",
+			amount: 40,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(0, CLOSED)).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func capturehold lock wallet failed: wallet is closed").Return().Once()
+			},
+			expectedHold: nil,
+			expectedErr:  errWalletClosed,
+		},
+		{
+			name:   "Database Error",
+			amount: 40,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, walletID).Return(newWalletRow(60, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, lockHoldQuery, holdID, walletID).Return(holdRow(ACTIVE, 0)).Once()
+				tx.On("QueryRow", mock.Anything, debitQuery, int64(40), walletID).Return(newMockRow(0, errors.New("db error"))).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func capturehold sql query failed").Return().Once()
+			},
+			expectedHold: nil,
+			expectedErr:  errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTx := new(MockTx)
+			mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+			mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+			tt.mockSetup(mockTx)
+			tt.mockLoggerFunc()
+
+			hold, err := repo.CaptureHold(walletID, holdID, tt.amount, requestctx.WithClientID(context.Background(), adminClient.ID))
+
+			assert.Equal(t, tt.expectedHold, hold)
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockPool.AssertExpectations(t)
+			mockTx.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"service/internal/config"
+	"service/internal/problem"
+	"service/internal/requestctx"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	defaultIdempotencyTTL   = config.DefaultIdempotencyTTL
+	defaultIdempotencyLease = config.DefaultIdempotencyLease
+	idempotencyPurgePeriod  = time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	errIdempotencyKeyReused     = errors.New("idempotency key was already used with a different request")
+	errIdempotencyKeyInProgress = errors.New("request with this idempotency key is still in progress")
+)
+
+// IdempotentResponse is the stored outcome of a request made with an Idempotency-Key.
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// responseRecorder passes the response through to the client and keeps a copy of it.
+// commitUncertain is set when the response reports a commit that timed out.
+type responseRecorder struct {
+	http.ResponseWriter
+	status          int
+	body            bytes.Buffer
+	commitUncertain bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotent makes next safe to retry: the first response for an Idempotency-Key is
+// stored and replayed for every later request carrying the same key and body.
+func (h *Handler) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if len(key) > maxIdempotencyKeyLength {
+			h.lg.ErrorCtx(ctx, "idempotency key is too long")
+			problem.Write(w, r, http.StatusBadRequest, problem.InvalidRequest, "idempotency key is too long")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+		if err != nil {
+			h.lg.ErrorCtx(ctx, "error read request body")
+			writeDecodeError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal := idempotencyPrincipal(ctx)
+		stored, err := h.repo.ReserveIdempotencyKey(principal, key, requestHash(r, body), h.idempotencyTTL, h.idempotencyLease, ctx)
+		if err == errIdempotencyKeyReused || err == errIdempotencyKeyInProgress {
+			h.lg.ErrorCtx(ctx, fmt.Sprintf("idempotency key = %s: %v", key, err))
+			writeError(w, r, err)
+			return
+		} else if err != nil {
+			h.lg.ErrorCtx(ctx, "error reserving idempotency key")
+			writeError(w, r, err)
+			return
+		}
+
+		if stored != nil {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			h.lg.InfoCtx(ctx, fmt.Sprintf("idempotency key = %s replayed", key))
+			return
+		}
+
+		// The request deadline may have passed; the key must still be released or saved.
+		ctx = context.WithoutCancel(ctx)
+		// A panic is recovered further out; free the key so the client can retry.
+		defer func() {
+			if p := recover(); p != nil {
+				if err := h.repo.ReleaseIdempotencyKey(principal, key, ctx); err != nil {
+					h.lg.ErrorCtx(ctx, fmt.Sprintf("error releasing idempotency key = %s", key))
+				}
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// Server errors are not final: free the key so the client can retry. A commit
+		// that timed out may still be applied, so its key stays reserved until the lease
+		// expires and a retry cannot apply the operation a second time in the meantime.
+		if rec.status >= http.StatusInternalServerError {
+			if rec.commitUncertain {
+				h.lg.ErrorCtx(ctx, fmt.Sprintf("idempotency key = %s kept until lease expiry: commit outcome is unknown", key))
+				return
+			}
+			if err := h.repo.ReleaseIdempotencyKey(principal, key, ctx); err != nil {
+				h.lg.ErrorCtx(ctx, fmt.Sprintf("error releasing idempotency key = %s", key))
+			}
+			return
+		}
+		response := IdempotentResponse{
+			StatusCode:  rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		}
+		if err := h.repo.SaveIdempotentResponse(principal, key, response, ctx); err != nil {
+			h.lg.ErrorCtx(ctx, fmt.Sprintf("error saving idempotency key = %s", key))
+		}
+	}
+}
+
+// purgeIdempotencyKeys deletes expired keys until the handler is closed.
+func (h *Handler) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			purged, err := h.repo.PurgeIdempotencyKeys(ctx)
+			if err != nil {
+				h.lg.ErrorCtx(ctx, "error purging idempotency keys")
+				continue
+			}
+			h.lg.DebugCtx(ctx, fmt.Sprintf("purged idempotency keys = %d", purged))
+		}
+	}
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// idempotencyPrincipal returns whom an Idempotency-Key belongs to: the API client, or
+// user:<subject> for an end user. Each principal has its own keys, so a key reused by
+// anyone else is a new request and never sees this caller's stored response.
+func idempotencyPrincipal(ctx context.Context) string {
+	if userID, ok := requestctx.UserIDFromContext(ctx); ok {
+		return "user:" + userID
+	}
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	return clientID
+}
+
+// ReserveIdempotencyKey claims key of principal for a new request. It returns a nil response when
+// the caller now owns the key and the stored response when the request is a replay.
+// Expired keys are reclaimed as if they had never been used, and so are keys whose
+// request is still unfinished after lease, e.g. because the service crashed.
+func (r *Repository) ReserveIdempotencyKey(principal, key, requestHash string, ttl, lease time.Duration, ctx context.Context) (*IdempotentResponse, error) {
+	result, err := r.db.Exec(ctx, `INSERT INTO idempotency_keys (principal, key, request_hash, expires_at, locked_until) VALUES ($1, $2, $3, now() + make_interval(secs => $4), now() + make_interval(secs => $5))
+ON CONFLICT (principal, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until
+WHERE idempotency_keys.expires_at < now() OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until < now())`, principal, key, requestHash, ttl.Seconds(), lease.Seconds())
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func reserveidempotencykey sql query failed")
+		return nil, err
+	}
+	if result.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var storedHash string
+	response := new(IdempotentResponse)
+	err = r.db.QueryRow(ctx, "SELECT request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), COALESCE(response_body, ''::bytea) FROM idempotency_keys WHERE principal = $1 AND key = $2", principal, key).
+		Scan(&storedHash, &response.StatusCode, &response.ContentType, &response.Body)
+	if err == pgx.ErrNoRows {
+		// The key expired and was purged between the two statements.
+		return nil, errIdempotencyKeyInProgress
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "Could not scan idempotency key")
+		return nil, err
+	}
+
+	if storedHash != requestHash {
+		return nil, errIdempotencyKeyReused
+	}
+	if response.StatusCode == 0 {
+		return nil, errIdempotencyKeyInProgress
+	}
+	return response, nil
+}
+
+func (r *Repository) SaveIdempotentResponse(principal, key string, response IdempotentResponse, ctx context.Context) error {
+	_, err := r.db.Exec(ctx, "UPDATE idempotency_keys SET status_code = $1, content_type = NULLIF($2, ''), response_body = $3 WHERE principal = $4 AND key = $5",
+		response.StatusCode, response.ContentType, response.Body, principal, key)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func saveidempotentresponse sql query failed")
+	}
+	return err
+}
+
+func (r *Repository) ReleaseIdempotencyKey(principal, key string, ctx context.Context) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE principal = $1 AND key = $2 AND status_code IS NULL", principal, key)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func releaseidempotencykey sql query failed")
+	}
+	return err
+}
+
+func (r *Repository) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at < now()")
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func purgeidempotencykeys sql query failed")
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,297 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIdempotent(t *testing.T) {
+	const (
+		key  = "retry-1"
+		body = `{"walletId":"123","operationType":"DEPOSIT","amount":100}`
+	)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", nil)
+	hash := requestHash(req, []byte(body))
+
+	tests := []struct {
+		name           string
+		key            string
+		nextStatus     int
+		nextErr        error
+		expectedStatus int
+		expectedBody   string
+		expectNext     bool
+		mockRepoFunc   func(repo *MockRepository)
+		mockLoggerFunc func(lg *MockLogger)
+	}{
+		{
+			name:           "Without Key",
+			key:            "",
+			nextStatus:     http.StatusOK,
+			expectedStatus: http.StatusOK,
+			expectNext:     true,
+			mockRepoFunc:   func(repo *MockRepository) {},
+			mockLoggerFunc: func(lg *MockLogger) {},
+		},
+		{
+			name:           "First Request Is Stored",
+			key:            key,
+			nextStatus:     http.StatusOK,
+			expectedStatus: http.StatusOK,
+			expectNext:     true,
+			mockRepoFunc: func(repo *MockRepository) {
+				repo.On("ReserveIdempotencyKey", "", key, hash, defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).Return((*IdempotentResponse)(nil), nil).Once()
+				repo.On("SaveIdempotentResponse", "", key, IdempotentResponse{StatusCode: http.StatusOK, Body: []byte("done")}, mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func(lg *MockLogger) {},
+		},
+		{
+			name:           "Replay Returns Stored Response",
+			key:            key,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "walletid not found\n",
+			expectNext:     false,
+			mockRepoFunc: func(repo *MockRepository) {
+				repo.On("ReserveIdempotencyKey", "", key, hash, defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).
+					Return(&IdempotentResponse{StatusCode: http.StatusNotFound, Body: []byte("walletid not found\n")}, nil).Once()
+			},
+			mockLoggerFunc: func(lg *MockLogger) {
+				lg.On("InfoCtx", mock.Anything, "idempotency key = retry-1 replayed").Return().Once()
+			},
+		},
+		{
+			name:           "Different Body Conflicts",
+			key:            key,
+			expectedStatus: http.StatusConflict,
+			expectNext:     false,
+			mockRepoFunc: func(repo *MockRepository) {
+				repo.On("ReserveIdempotencyKey", "", key, hash, defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).Return((*IdempotentResponse)(nil), errIdempotencyKeyReused).Once()
+			},
+			mockLoggerFunc: func(lg *MockLogger) {
+				lg.On("ErrorCtx", mock.Anything, "idempotency key = retry-1: idempotency key was already used with a different request").Return().Once()
+			},
+		},
+		{
+			name:           "Server Error Releases Key",
+			key:            key,
+			nextStatus:     http.StatusInternalServerError,
+			expectedStatus: http.StatusInternalServerError,
+			expectNext:     true,
+			mockRepoFunc: func(repo *MockRepository) {
+				repo.On("ReserveIdempotencyKey", "", key, hash, defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).Return((*IdempotentResponse)(nil), nil).Once()
+				repo.On("ReleaseIdempotencyKey", "", key, mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func(lg *MockLogger) {},
+		},
+		{
+			name:           "Commit Timeout Keeps Key",
+			key:            key,
+			nextErr:        fmt.Errorf("%w: %w", errCommitUncertain, context.DeadlineExceeded),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectNext:     true,
+			mockRepoFunc: func(repo *MockRepository) {
+				repo.On("ReserveIdempotencyKey", "", key, hash, defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).Return((*IdempotentResponse)(nil), nil).Once()
+			},
+			mockLoggerFunc: func(lg *MockLogger) {
+				lg.On("ErrorCtx", mock.Anything, "idempotency key = retry-1 kept until lease expiry: commit outcome is unknown").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockLogger := new(MockLogger)
+			mockRepo := new(MockRepository)
+			handler := &Handler{repo: mockRepo, lg: mockLogger, idempotencyTTL: defaultIdempotencyTTL, idempotencyLease: defaultIdempotencyLease}
+			tt.mockRepoFunc(mockRepo)
+			tt.mockLoggerFunc(mockLogger)
+
+			called := false
+			next := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				if tt.nextErr != nil {
+					writeError(w, r, tt.nextErr)
+					return
+				}
+				w.WriteHeader(tt.nextStatus)
+				w.Write([]byte("done"))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", bytes.NewBufferString(body))
+			if tt.key != "" {
+				req.Header.Set(IdempotencyKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			handler.Idempotent(next)(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			assert.Equal(t, tt.expectNext, called)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+			}
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_ReserveIdempotencyKey(t *testing.T) {
+	mockLogger := new(MockLogger)
+
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		insertQuery = `INSERT INTO idempotency_keys (principal, key, request_hash, expires_at, locked_until) VALUES ($1, $2, $3, now() + make_interval(secs => $4), now() + make_interval(secs => $5))
+ON CONFLICT (principal, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until
+WHERE idempotency_keys.expires_at < now() OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until < now())`
+		selectQuery = "SELECT request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), COALESCE(response_body, ''::bytea) FROM idempotency_keys WHERE principal = $1 AND key = $2"
+	)
+
+	tests := []struct {
+		name             string
+		mockSetup        func()
+		mockLoggerFunc   func()
+		expectedResponse *IdempotentResponse
+		expectedErr      error
+	}{
+		{
+			name: "New Key Is Reserved",
+			mockSetup: func() {
+				mockPool.On("Exec", mock.Anything, insertQuery, "client-1", "key", "hash", float64(60), float64(10)).
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+			},
+			mockLoggerFunc:   func() {},
+			expectedResponse: nil,
+			expectedErr:      nil,
+		},
+		{
+			name: "Completed Key Is Replayed",
+			mockSetup: func() {
+				mockPool.On("Exec", mock.Anything, insertQuery, "client-1", "key", "hash", float64(60), float64(10)).
+					Return(pgconn.NewCommandTag("INSERT 0 0"), nil).Once()
+				mockPool.On("QueryRow", mock.Anything, selectQuery, "client-1", "key").
+					Return(&mockRow{values: []any{"hash", 200, "", []byte{}}}).Once()
+			},
+			mockLoggerFunc:   func() {},
+			expectedResponse: &IdempotentResponse{StatusCode: 200, Body: []byte{}},
+			expectedErr:      nil,
+		},
+		{
+			name: "Different Request Hash",
+			mockSetup: func() {
+				mockPool.On("Exec", mock.Anything, insertQuery, "client-1", "key", "hash", float64(60), float64(10)).
+					Return(pgconn.NewCommandTag("INSERT 0 0"), nil).Once()
+				mockPool.On("QueryRow", mock.Anything, selectQuery, "client-1", "key").
+					Return(&mockRow{values: []any{"other", 200, "", []byte{}}}).Once()
+			},
+			mockLoggerFunc:   func() {},
+			expectedResponse: nil,
+			expectedErr:      errIdempotencyKeyReused,
+		},
+		{
+			name: "Request In Progress",
+			mockSetup: func() {
+				mockPool.On("Exec", mock.Anything, insertQuery, "client-1", "key", "hash", float64(60), float64(10)).
+					Return(pgconn.NewCommandTag("INSERT 0 0"), nil).Once()
+				mockPool.On("QueryRow", mock.Anything, selectQuery, "client-1", "key").
+					Return(&mockRow{values: []any{"hash", 0, "", []byte{}}}).Once()
+			},
+			mockLoggerFunc:   func() {},
+			expectedResponse: nil,
+			expectedErr:      errIdempotencyKeyInProgress,
+		},
+		{
+			name: "Database Error",
+			mockSetup: func() {
+				mockPool.On("Exec", mock.Anything, insertQuery, "client-1", "key", "hash", float64(60), float64(10)).
+					Return(pgconn.CommandTag{}, errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func reserveidempotencykey sql query failed").Return().Once()
+			},
+			expectedResponse: nil,
+			expectedErr:      errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+			tt.mockLoggerFunc()
+
+			response, err := repo.ReserveIdempotencyKey("client-1", "key", "hash", time.Minute, 10*time.Second, context.Background())
+
+			assert.Equal(t, tt.expectedResponse, response)
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockPool.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIdempotent_ScopedToEndUser(t *testing.T) {
+	const (
+		key  = "retry-1"
+		body = `{"walletId":"123","operationType":"DEPOSIT","amount":100}`
+	)
+	bob := &APIClient{Name: "user", Scopes: userScopes, OwnerID: "bob"}
+	req := withClient(httptest.NewRequest(http.MethodPost, "/api/v1/wallet", bytes.NewBufferString(body)), bob)
+	req.Header.Set(IdempotencyKeyHeader, key)
+
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, idempotencyTTL: defaultIdempotencyTTL, idempotencyLease: defaultIdempotencyLease}
+	// The key is bob's own even if alice used it before.
+	hash := requestHash(req, []byte(body))
+	mockRepo.On("ReserveIdempotencyKey", "user:bob", key, hash, defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).Return((*IdempotentResponse)(nil), nil).Once()
+	mockRepo.On("SaveIdempotentResponse", "user:bob", key, IdempotentResponse{StatusCode: http.StatusOK}, mock.Anything).Return(nil).Once()
+
+	called := false
+	w := httptest.NewRecorder()
+	handler.Idempotent(func(w http.ResponseWriter, r *http.Request) { called = true })(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, called)
+	mockRepo.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestIdempotent_PanicReleasesKey(t *testing.T) {
+	const body = `{"walletId":"123","operationType":"DEPOSIT","amount":100}`
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, idempotencyTTL: defaultIdempotencyTTL, idempotencyLease: defaultIdempotencyLease}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", bytes.NewBufferString(body))
+	req.Header.Set(IdempotencyKeyHeader, "retry-1")
+	mockRepo.On("ReserveIdempotencyKey", "", "retry-1", requestHash(req, []byte(body)), defaultIdempotencyTTL, defaultIdempotencyLease, mock.Anything).Return((*IdempotentResponse)(nil), nil).Once()
+	mockRepo.On("ReleaseIdempotencyKey", "", "retry-1", mock.Anything).Return(nil).Once()
+
+	next := func(w http.ResponseWriter, r *http.Request) { panic("boom") }
+	assert.PanicsWithValue(t, "boom", func() {
+		handler.Idempotent(next)(httptest.NewRecorder(), req)
+	})
+
+	mockRepo.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
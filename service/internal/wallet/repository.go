@@ -3,8 +3,14 @@ package wallet
 import (
 	"context"
 	"errors"
+	"fmt"
 	"service/internal/config"
 	"service/internal/logger"
+	"service/internal/metrics"
+	"service/internal/requestctx"
+	"service/internal/tracing"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -13,93 +19,413 @@ import (
 )
 
 type RepositoryInterface interface {
-	Deposit(walletID string, amount int64, ctx context.Context) error
-	Withdraw(walletID string, amount int64, ctx context.Context) error
-	GetBalance(walletID string, ctx context.Context) (int64, error)
+	Deposit(walletID string, amount int64, currency string, ctx context.Context) error
+	Withdraw(walletID string, amount int64, currency string, ctx context.Context) error
+	Transfer(fromWalletID, toWalletID string, amount int64, currency string, ctx context.Context) error
+	GetBalance(walletID string, ctx context.Context) (Balance, error)
+	CreateWallet(ownerID, externalRef, currency string, ctx context.Context) (*Wallet, error)
+	GetWallet(walletID string, ctx context.Context) (*Wallet, error)
+	GetWalletOwners(walletIDs []string, ctx context.Context) (map[string]string, error)
+	CloseWallet(walletID string, ctx context.Context) (*Wallet, error)
+	ApplyBatch(operations []WalletOperationRequest, atomic bool, ctx context.Context) ([]error, error)
+	CreateHold(walletID string, amount int64, currency string, ttl time.Duration, ctx context.Context) (*Hold, error)
+	CaptureHold(walletID, holdID string, amount int64, ctx context.Context) (*Hold, error)
+	VoidHold(walletID, holdID string, ctx context.Context) (*Hold, error)
+	ExpireHolds(ctx context.Context) (int64, error)
+	GetTransactions(walletID string, filter TransactionFilter, ctx context.Context) ([]Transaction, error)
+	ReserveIdempotencyKey(principal, key, requestHash string, ttl, lease time.Duration, ctx context.Context) (*IdempotentResponse, error)
+	SaveIdempotentResponse(principal, key string, response IdempotentResponse, ctx context.Context) error
+	ReleaseIdempotencyKey(principal, key string, ctx context.Context) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+	GetAPIClient(keyHash string, ctx context.Context) (*APIClient, error)
+	UseNonce(clientID, nonce string, ttl time.Duration, ctx context.Context) error
+	PurgeNonces(ctx context.Context) (int64, error)
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int64, error)
+	PoolStats() PoolStats
 	Close()
 }
 
 type DBPool interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
 	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Ping(ctx context.Context) error
+	Stat() *pgxpool.Stat
 	Close()
 }
 
-type Repository struct {
-	db  DBPool
-	lg  logger.Logger
-	ctx context.Context
-}
-
+// Ledger entry types written for each side of a transfer and for a captured hold.
 const (
-	maxconns = 2000
+	TRANSFER_IN  string = "TRANSFER_IN"
+	TRANSFER_OUT string = "TRANSFER_OUT"
+	CAPTURE      string = "CAPTURE"
 )
 
-var errWalletid, errWithdraw = errors.New("walletid not found"), errors.New("insufficient funds or walletid not found")
+// Balance of a wallet in minor units of its currency. Ledger is the booked amount;
+// Available excludes active holds.
+type Balance struct {
+	Ledger     int64  `json:"ledger"`
+	Available  int64  `json:"available"`
+	Currency   string `json:"currency"`
+	MinorUnits int    `json:"minorUnits"`
+}
+
+// heldAmountSQL sums the unexpired active holds of the wallet row aliased as w.
+const heldAmountSQL = "COALESCE((SELECT SUM(h.amount) FROM wallet_holds h WHERE h.wallet_id = w.id AND h.status = 'ACTIVE' AND h.expires_at > now()), 0)::bigint"
+
+// Transaction is a single row of the append-only wallet ledger.
+type Transaction struct {
+	ID                   int64     `json:"id"`
+	WalletID             string    `json:"walletId"`
+	OperationType        string    `json:"operationType"`
+	Amount               int64     `json:"amount"`
+	BalanceAfter         int64     `json:"balanceAfter"`
+	CounterpartyWalletID string    `json:"counterpartyWalletId,omitempty"`
+	RequestID            string    `json:"requestId"`
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// TransactionFilter narrows a ledger query. Zero values mean "no restriction".
+// Rows are returned newest first; Cursor is the ID of the last row already seen.
+type TransactionFilter struct {
+	OperationType string
+	From          time.Time
+	To            time.Time
+	MinAmount     *int64
+	MaxAmount     *int64
+	Cursor        int64
+	Limit         int
+}
+
+type Repository struct {
+	db DBPool
+	lg logger.Logger
+}
+
+var errWalletid, errInsufficientFunds = errors.New("walletid not found"), errors.New("insufficient funds")
 
 func NewRepository(lg logger.Logger, ctx context.Context, cfg *config.ConfigAdr) RepositoryInterface {
 	conf, err := pgxpool.ParseConfig(cfg.Database_url)
 	if err != nil {
 		lg.FatalCtx(ctx, "Could not parse database URL: ", err)
 	}
-	conf.MaxConns = maxconns
+	applyPoolConfig(conf, cfg)
+	conf.ConnConfig.Tracer = tracing.QueryTracer{}
 
 	pg, err := pgxpool.NewWithConfig(ctx, conf)
 	if err != nil {
 		lg.FatalCtx(ctx, "Could not create connection pool: ", err)
 	}
+	metrics.RegisterPool(pg)
 	rep := new(Repository)
 	rep.db = pg
 	rep.lg = lg
-	rep.ctx = ctx
 	return rep
 }
 
+// applyPoolConfig overrides the pool settings that are set in cfg.
+func applyPoolConfig(conf *pgxpool.Config, cfg *config.ConfigAdr) {
+	if cfg.Db_max_conns > 0 {
+		conf.MaxConns = cfg.Db_max_conns
+	}
+	if cfg.Db_min_conns > 0 {
+		conf.MinConns = cfg.Db_min_conns
+	}
+	if cfg.Db_max_conn_lifetime > 0 {
+		conf.MaxConnLifetime = cfg.Db_max_conn_lifetime
+	}
+	if cfg.Db_max_conn_idle_time > 0 {
+		conf.MaxConnIdleTime = cfg.Db_max_conn_idle_time
+	}
+	if cfg.Db_health_check_period > 0 {
+		conf.HealthCheckPeriod = cfg.Db_health_check_period
+	}
+	if cfg.Statement_timeout > 0 {
+		conf.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.Statement_timeout.Milliseconds(), 10)
+	}
+}
+
 func (r *Repository) Close() {
 	r.db.Close()
 }
 
-func (r *Repository) Deposit(walletID string, amount int64, ctx context.Context) error {
-	r.ctx = ctx
-	result, err := r.db.Exec(r.ctx, "UPDATE wallets SET balance = balance + $1 WHERE id = $2", amount, walletID)
+// Deposit credits amount to the wallet. An empty currency skips the currency check.
+func (r *Repository) Deposit(walletID string, amount int64, currency string, ctx context.Context) error {
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		r.lg.ErrorCtx(r.ctx, "func deposit sql query failed")
+		r.lg.ErrorCtx(ctx, "func deposit begin transaction failed")
 		return err
 	}
-	rowsAffected := result.RowsAffected()
+	defer tx.Rollback(ctx)
 
-	if rowsAffected == 0 {
-		r.lg.ErrorCtx(r.ctx, "func deposit walletid not found")
-		return errWalletid
+	if _, err := r.lockWallet(tx, walletID, currency, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, fmt.Sprintf("func deposit lock wallet failed: %v", err))
+		return err
 	}
-	return err
+
+	var balance int64
+	err = tx.QueryRow(ctx, "UPDATE wallets SET balance = balance + $1 WHERE id = $2 RETURNING balance", amount, walletID).Scan(&balance)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func deposit sql query failed")
+		return err
+	}
+
+	if err := r.recordTransaction(tx, Transaction{WalletID: walletID, OperationType: DEPOSIT, Amount: amount, BalanceAfter: balance}, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func deposit record transaction failed")
+		return err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func deposit commit failed")
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) Withdraw(walletID string, amount int64, currency string, ctx context.Context) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func withdraw begin transaction failed")
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	balance, err := r.lockWallet(tx, walletID, currency, ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, fmt.Sprintf("func withdraw lock wallet failed: %v", err))
+		return err
+	}
+	if balance < amount {
+		r.lg.ErrorCtx(ctx, "func withdraw insufficient funds")
+		return errInsufficientFunds
+	}
+
+	err = tx.QueryRow(ctx, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 RETURNING balance", amount, walletID).Scan(&balance)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func withdraw sql query failed")
+		return err
+	}
+
+	if err := r.recordTransaction(tx, Transaction{WalletID: walletID, OperationType: WITHDRAW, Amount: amount, BalanceAfter: balance}, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func withdraw record transaction failed")
+		return err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func withdraw commit failed")
+		return err
+	}
+	return nil
 }
 
-func (r *Repository) Withdraw(walletID string, amount int64, ctx context.Context) error {
-	r.ctx = ctx
-	result, err := r.db.Exec(r.ctx, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 AND balance >= $1", amount, walletID)
+// Transfer moves amount between two wallets of the same currency in a single database transaction.
+func (r *Repository) Transfer(fromWalletID, toWalletID string, amount int64, currency string, ctx context.Context) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer begin transaction failed")
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Both rows are locked in primary key order, so two opposite transfers
+	// queue up on the same wallet instead of deadlocking.
+	rows, err := tx.Query(ctx, "SELECT w.id = $1, w.balance - "+heldAmountSQL+", w.status, w.currency FROM wallets w WHERE w.id IN ($1, $2) ORDER BY w.id FOR UPDATE", fromWalletID, toWalletID)
 	if err != nil {
-		r.lg.ErrorCtx(r.ctx, "func withdraw sql query failed")
+		r.lg.ErrorCtx(ctx, "func transfer lock wallets failed")
 		return err
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		r.lg.ErrorCtx(r.ctx, "func withdraw insufficient funds or walletid not found")
-		return errWithdraw
+	var locked int
+	var closed bool
+	var fromBalance int64
+	currencies := make(map[string]bool, 2)
+	for rows.Next() {
+		var isSource bool
+		var balance int64
+		var status, walletCurrency string
+		if err := rows.Scan(&isSource, &balance, &status, &walletCurrency); err != nil {
+			rows.Close()
+			r.lg.ErrorCtx(ctx, "Could not scan wallet")
+			return err
+		}
+		if isSource {
+			fromBalance = balance
+		}
+		closed = closed || status != ACTIVE
+		currencies[walletCurrency] = true
+		locked++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer lock wallets failed")
+		return err
+	}
+
+	if locked != 2 {
+		r.lg.ErrorCtx(ctx, "func transfer walletid not found")
+		return errWalletid
+	}
+	if closed {
+		r.lg.ErrorCtx(ctx, "func transfer wallet closed")
+		return errWalletClosed
+	}
+	if len(currencies) != 1 || (currency != "" && !currencies[currency]) {
+		r.lg.ErrorCtx(ctx, "func transfer currency mismatch")
+		return errCurrencyMismatch
+	}
+	if fromBalance < amount {
+		r.lg.ErrorCtx(ctx, "func transfer insufficient funds")
+		return errInsufficientFunds
+	}
+
+	var fromBalanceAfter, toBalanceAfter int64
+	if err := tx.QueryRow(ctx, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 RETURNING balance", amount, fromWalletID).Scan(&fromBalanceAfter); err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer debit failed")
+		return err
+	}
+	if err := tx.QueryRow(ctx, "UPDATE wallets SET balance = balance + $1 WHERE id = $2 RETURNING balance", amount, toWalletID).Scan(&toBalanceAfter); err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer credit failed")
+		return err
+	}
+
+	if err := r.recordTransaction(tx, Transaction{WalletID: fromWalletID, OperationType: TRANSFER_OUT, Amount: amount, BalanceAfter: fromBalanceAfter, CounterpartyWalletID: toWalletID}, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer record transaction failed")
+		return err
+	}
+	if err := r.recordTransaction(tx, Transaction{WalletID: toWalletID, OperationType: TRANSFER_IN, Amount: amount, BalanceAfter: toBalanceAfter, CounterpartyWalletID: fromWalletID}, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer record transaction failed")
+		return err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func transfer commit failed")
+		return err
+	}
+	return nil
+}
+
+// commitTx commits tx. A deadline hit while COMMIT is in flight leaves its outcome
+// unknown, so the error is marked with errCommitUncertain.
+func commitTx(tx pgx.Tx, ctx context.Context) error {
+	err := tx.Commit(ctx)
+	if err != nil && isTimeout(err) {
+		return fmt.Errorf("%w: %w", errCommitUncertain, err)
 	}
 	return err
 }
 
-func (r *Repository) GetBalance(walletID string, ctx context.Context) (int64, error) {
-	r.ctx = ctx
+// lockWallet locks an active wallet row until the end of tx and returns its available
+// balance. A non-empty currency must match the currency of the wallet.
+func (r *Repository) lockWallet(tx pgx.Tx, walletID, currency string, ctx context.Context) (int64, error) {
 	var balance int64
-	err := r.db.QueryRow(r.ctx, "SELECT balance FROM wallets WHERE id = $1", walletID).Scan(&balance)
+	var status, walletCurrency string
+	err := tx.QueryRow(ctx, "SELECT w.balance - "+heldAmountSQL+", w.status, w.currency FROM wallets w WHERE w.id = $1 FOR UPDATE", walletID).Scan(&balance, &status, &walletCurrency)
 	if err == pgx.ErrNoRows {
-		r.lg.ErrorCtx(r.ctx, "func getbalance walletid not found")
 		return 0, errWalletid
 	} else if err != nil {
-		r.lg.ErrorCtx(r.ctx, "Could not scan wallet")
 		return 0, err
 	}
+	if status != ACTIVE {
+		return 0, errWalletClosed
+	}
+	if currency != "" && currency != walletCurrency {
+		return 0, errCurrencyMismatch
+	}
+	return balance, nil
+}
+
+const insertTransactionSQL = "INSERT INTO wallet_transactions (wallet_id, operation_type, amount, balance_after, counterparty_wallet_id, request_id, client_id, user_id) VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, ''), NULLIF($7, '')::uuid, NULLIF($8, ''))"
+
+// recordTransaction appends a ledger row inside the transaction that changed the balance.
+func (r *Repository) recordTransaction(tx pgx.Tx, t Transaction, ctx context.Context) error {
+	requestID, _ := requestctx.RequestIDFromContext(ctx)
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	userID, _ := requestctx.UserIDFromContext(ctx)
+	_, err := tx.Exec(ctx, insertTransactionSQL, t.WalletID, t.OperationType, t.Amount, t.BalanceAfter, t.CounterpartyWalletID, requestID, clientID, userID)
+	return err
+}
+
+func (r *Repository) GetBalance(walletID string, ctx context.Context) (Balance, error) {
+	var balance Balance
+	err := r.db.QueryRow(ctx, "SELECT w.balance, w.balance - "+heldAmountSQL+", w.currency FROM wallets w WHERE w.id = $1", walletID).
+		Scan(&balance.Ledger, &balance.Available, &balance.Currency)
+	if err == pgx.ErrNoRows {
+		r.lg.ErrorCtx(ctx, "func getbalance walletid not found")
+		return Balance{}, errWalletid
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "Could not scan wallet")
+		return Balance{}, err
+	}
+	balance.MinorUnits = currencyMinorUnits[balance.Currency]
 	return balance, nil
 }
+
+func (r *Repository) GetTransactions(walletID string, filter TransactionFilter, ctx context.Context) ([]Transaction, error) {
+	query, args := buildTransactionsQuery(walletID, filter)
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func gettransactions sql query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := make([]Transaction, 0)
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.WalletID, &t.OperationType, &t.Amount, &t.BalanceAfter, &t.CounterpartyWalletID, &t.RequestID, &t.CreatedAt); err != nil {
+			r.lg.ErrorCtx(ctx, "Could not scan transaction")
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		r.lg.ErrorCtx(ctx, "func gettransactions rows iteration failed")
+		return nil, err
+	}
+
+	if len(transactions) == 0 {
+		var count int64
+		if err := r.db.QueryRow(ctx, "SELECT count(*) FROM wallets WHERE id = $1", walletID).Scan(&count); err != nil {
+			r.lg.ErrorCtx(ctx, "Could not scan wallet")
+			return nil, err
+		}
+		if count == 0 {
+			r.lg.ErrorCtx(ctx, "func gettransactions walletid not found")
+			return nil, errWalletid
+		}
+	}
+	return transactions, nil
+}
+
+func buildTransactionsQuery(walletID string, filter TransactionFilter) (string, []any) {
+	query := "SELECT id, wallet_id::text, operation_type, amount, balance_after, COALESCE(counterparty_wallet_id::text, ''), COALESCE(request_id, ''), created_at FROM wallet_transactions WHERE wallet_id = $1"
+	args := []any{walletID}
+
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		query += fmt.Sprintf(" AND "+condition, len(args))
+	}
+	if filter.Cursor > 0 {
+		where("id < $%d", filter.Cursor)
+	}
+	if filter.OperationType != "" {
+		where("operation_type = $%d", filter.OperationType)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+	if filter.MinAmount != nil {
+		where("amount >= $%d", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		where("amount <= $%d", *filter.MaxAmount)
+	}
+
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return query, args
+}
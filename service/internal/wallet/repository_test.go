@@ -3,67 +3,202 @@ package wallet
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
+const insertTransactionQuery = "INSERT INTO wallet_transactions (wallet_id, operation_type, amount, balance_after, counterparty_wallet_id, request_id, client_id, user_id) VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, ''), NULLIF($7, '')::uuid, NULLIF($8, ''))"
+
 type MockPool struct {
 	mock.Mock
 }
 
+func (m *MockPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	ret := m.Called(ctx)
+	return ret.Get(0).(pgx.Tx), ret.Error(1)
+}
+
 func (m *MockPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
-	ret := m.Called(ctx, sql, args[0], args[1])
+	ret := m.Called(append([]any{ctx, sql}, args...)...)
 	return ret.Get(0).(pgconn.CommandTag), ret.Error(1)
 }
 
+func (m *MockPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ret := m.Called(append([]any{ctx, sql}, args...)...)
+	return ret.Get(0).(pgx.Rows), ret.Error(1)
+}
+
 func (m *MockPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
-	ret := m.Called(ctx, sql, args[0])
+	ret := m.Called(append([]any{ctx, sql}, args...)...)
 	return ret.Get(0).(pgx.Row)
 }
 
+func (m *MockPool) Ping(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+func (m *MockPool) Stat() *pgxpool.Stat {
+	return m.Called().Get(0).(*pgxpool.Stat)
+}
+
 func (m *MockPool) Close() {
 	m.Called()
 }
 
+// MockTx embeds pgx.Tx so only the methods used by the repository have to be mocked.
+type MockTx struct {
+	pgx.Tx
+	mock.Mock
+}
+
+func (m *MockTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ret := m.Called(append([]any{ctx, sql}, args...)...)
+	return ret.Get(0).(pgconn.CommandTag), ret.Error(1)
+}
+
+func (m *MockTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ret := m.Called(append([]any{ctx, sql}, args...)...)
+	return ret.Get(0).(pgx.Row)
+}
+
+func (m *MockTx) Commit(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+func (m *MockTx) Rollback(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+func (m *MockTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ret := m.Called(append([]any{ctx, sql}, args...)...)
+	return ret.Get(0).(pgx.Rows), ret.Error(1)
+}
+
+func (m *MockTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return m.Called(ctx, b).Get(0).(pgx.BatchResults)
+}
+
+// mockBatchResults embeds pgx.BatchResults and fails Close with err.
+type mockBatchResults struct {
+	pgx.BatchResults
+	err error
+}
+
+func (r *mockBatchResults) Close() error {
+	return r.err
+}
+
+// mockRows embeds pgx.Rows and serves rows of plain values from memory.
+type mockRows struct {
+	pgx.Rows
+	rows [][]any
+	pos  int
+	err  error
+}
+
+func newTransactionRows(transactions []Transaction) *mockRows {
+	rows := new(mockRows)
+	for _, t := range transactions {
+		rows.rows = append(rows.rows, []any{t.ID, t.WalletID, t.OperationType, t.Amount, t.BalanceAfter, t.CounterpartyWalletID, t.RequestID, t.CreatedAt})
+	}
+	return rows
+}
+
+func (r *mockRows) Next() bool {
+	if r.pos >= len(r.rows) {
+		return false
+	}
+	r.pos++
+	return true
+}
+
+func (r *mockRows) Scan(dest ...interface{}) error {
+	return scanValues(r.rows[r.pos-1], dest)
+}
+
+func (r *mockRows) Err() error {
+	return r.err
+}
+
+func (r *mockRows) Close() {}
+
 type mockRow struct {
-	balance int64
-	err     error
+	values []any
+	err    error
 }
 
 func (r *mockRow) Scan(dest ...interface{}) error {
 	if r.err != nil {
 		return r.err
 	}
-	if len(dest) != 1 {
-		return errors.New("expected one destination")
-	}
-	*dest[0].(*int64) = r.balance
-	return nil
+	return scanValues(r.values, dest)
 }
 
 func newMockRow(balance int64, err error) *mockRow {
 	return &mockRow{
-		balance: balance,
-		err:     err,
+		values: []any{balance},
+		err:    err,
 	}
 }
 
+// scanValues copies a row of plain Go values into Scan destinations.
+func scanValues(row []any, dest []any) error {
+	if len(dest) != len(row) {
+		return errors.New("unexpected number of destinations")
+	}
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *int:
+			*d = row[i].(int)
+		case *int64:
+			*d = row[i].(int64)
+		case *string:
+			*d = row[i].(string)
+		case *bool:
+			*d = row[i].(bool)
+		case *[]byte:
+			*d = row[i].([]byte)
+		case *[]string:
+			*d = row[i].([]string)
+		case *time.Time:
+			*d = row[i].(time.Time)
+		case **time.Time:
+			*d = row[i].(*time.Time)
+		default:
+			return errors.New("unsupported destination type")
+		}
+	}
+	return nil
+}
+
+const lockWalletQuery = "SELECT w.balance - " + heldAmountSQL + ", w.status, w.currency FROM wallets w WHERE w.id = $1 FOR UPDATE"
+
+func newWalletRow(balance int64, status string) *mockRow {
+	return &mockRow{values: []any{balance, status, "RUB"}}
+}
+
 func TestRepository_Deposit(t *testing.T) {
 	mockLogger := new(MockLogger)
 
 	mockPool := new(MockPool)
-	repo := &Repository{db: mockPool, lg: mockLogger, ctx: context.Background()}
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const updateQuery = "UPDATE wallets SET balance = balance + $1 WHERE id = $2 RETURNING balance"
 
 	tests := []struct {
 		name           string
 		walletID       string
 		amount         int64
-		mockSetup      func()
+		currency       string
+		mockSetup      func(tx *MockTx)
 		expectedErr    error
 		mockLoggerFunc func()
 	}{
@@ -71,49 +206,113 @@ func TestRepository_Deposit(t *testing.T) {
 			name:     "Successful Deposit",
 			walletID: "123",
 			amount:   100,
-			mockSetup: func() {
-				mockPool.On("Exec", mock.Anything, "UPDATE wallets SET balance = balance + $1 WHERE id = $2", int64(100), "123").
-					Return(pgconn.NewCommandTag("UPDATE 1"), nil).Once()
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(100), "123").
+					Return(newMockRow(int64(300), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "123", DEPOSIT, int64(100), int64(300), "", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
 			},
 			mockLoggerFunc: func() {
 
 			},
 			expectedErr: nil,
 		},
+		{
+			name:     "Successful Deposit In Wallet Currency",
+			walletID: "123",
+			amount:   100,
+			currency: "RUB",
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(100), "123").
+					Return(newMockRow(int64(300), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "123", DEPOSIT, int64(100), int64(300), "", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedErr:    nil,
+		},
+		{
+			name:     "Currency Mismatch",
+			walletID: "123",
+			amount:   100,
+			currency: "USD",
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func deposit lock wallet failed: currency does not match the wallet currency").Return().Once()
+			},
+			expectedErr: errCurrencyMismatch,
+		},
 		{
 			name:     "Wallet Not Found",
 			walletID: "123",
 			amount:   100,
-			mockSetup: func() {
-				mockPool.On("Exec", mock.Anything, "UPDATE wallets SET balance = balance + $1 WHERE id = $2", int64(100), "123").
-					Return(pgconn.NewCommandTag("UPDATE 0"), nil).Once()
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(&mockRow{err: pgx.ErrNoRows}).Once()
 			},
 			mockLoggerFunc: func() {
-				mockLogger.On("ErrorCtx", mock.Anything, "func deposit walletid not found").Return().Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "func deposit lock wallet failed: walletid not found").Return().Once()
 			},
 			expectedErr: errWalletid,
 		},
+		{
+			name:     "Wallet Closed",
+			walletID: "123",
+			amount:   100,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(0, CLOSED)).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func deposit lock wallet failed: wallet is closed").Return().Once()
+			},
+			expectedErr: errWalletClosed,
+		},
 		{
 			name:     "Database Error",
 			walletID: "123",
 			amount:   100,
-			mockSetup: func() {
-				mockPool.On("Exec", mock.Anything, "UPDATE wallets SET balance = balance + $1 WHERE id = $2", int64(100), "123").
-					Return(pgconn.CommandTag{}, errors.New("db error")).Once()
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(100), "123").
+					Return(newMockRow(int64(0), errors.New("db error"))).Once()
 			},
 			mockLoggerFunc: func() {
 				mockLogger.On("ErrorCtx", mock.Anything, "func deposit sql query failed").Return().Once()
 			},
 			expectedErr: errors.New("db error"),
 		},
+		{
+			name:     "Ledger Insert Error",
+			walletID: "123",
+			amount:   100,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(100), "123").
+					Return(newMockRow(int64(300), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "123", DEPOSIT, int64(100), int64(300), "", "", "", "").
+					Return(pgconn.CommandTag{}, errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func deposit record transaction failed").Return().Once()
+			},
+			expectedErr: errors.New("db error"),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup()
+			mockTx := new(MockTx)
+			mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+			mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+			tt.mockSetup(mockTx)
 			tt.mockLoggerFunc()
 
-			err := repo.Deposit(tt.walletID, tt.amount, context.Background())
+			err := repo.Deposit(tt.walletID, tt.amount, tt.currency, context.Background())
 
 			if tt.expectedErr != nil {
 				assert.Error(t, err)
@@ -123,6 +322,7 @@ func TestRepository_Deposit(t *testing.T) {
 			}
 
 			mockPool.AssertExpectations(t)
+			mockTx.AssertExpectations(t)
 			mockLogger.AssertExpectations(t)
 		})
 	}
@@ -132,13 +332,16 @@ func TestRepository_Withdraw(t *testing.T) {
 	mockLogger := new(MockLogger)
 
 	mockPool := new(MockPool)
-	repo := &Repository{db: mockPool, lg: mockLogger, ctx: context.Background()}
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const updateQuery = "UPDATE wallets SET balance = balance - $1 WHERE id = $2 RETURNING balance"
 
 	tests := []struct {
 		name           string
 		walletID       string
 		amount         int64
-		mockSetup      func()
+		currency       string
+		mockSetup      func(tx *MockTx)
 		mockLoggerFunc func()
 		expectedErr    error
 	}{
@@ -146,9 +349,13 @@ func TestRepository_Withdraw(t *testing.T) {
 			name:     "Successful Withdraw",
 			walletID: "123",
 			amount:   50,
-			mockSetup: func() {
-				mockPool.On("Exec", mock.Anything, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 AND balance >= $1", int64(50), "123").
-					Return(pgconn.NewCommandTag("UPDATE 1"), nil).Once()
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(50), "123").
+					Return(newMockRow(int64(150), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "123", WITHDRAW, int64(50), int64(150), "", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
 			},
 			mockLoggerFunc: func() {
 
@@ -159,36 +366,217 @@ func TestRepository_Withdraw(t *testing.T) {
 			name:     "Insufficient Funds",
 			walletID: "123",
 			amount:   50,
-			mockSetup: func() {
-				mockPool.On("Exec", mock.Anything, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 AND balance >= $1", int64(50), "123").
-					Return(pgconn.NewCommandTag("UPDATE 0"), nil).Once()
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(40, ACTIVE)).Once()
 			},
 			mockLoggerFunc: func() {
-				mockLogger.On("ErrorCtx", mock.Anything, "func withdraw insufficient funds or walletid not found").Return().Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "func withdraw insufficient funds").Return().Once()
 			},
-			expectedErr: errWithdraw,
+			expectedErr: errInsufficientFunds,
+		},
+		{
+			name:     "Wallet Not Found",
+			walletID: "123",
+			amount:   50,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(&mockRow{err: pgx.ErrNoRows}).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func withdraw lock wallet failed: walletid not found").Return().Once()
+			},
+			expectedErr: errWalletid,
 		},
 		{
 			name:     "Database Error",
 			walletID: "123",
 			amount:   50,
-			mockSetup: func() {
-				mockPool.On("Exec", mock.Anything, "UPDATE wallets SET balance = balance - $1 WHERE id = $2 AND balance >= $1", int64(50), "123").
-					Return(pgconn.CommandTag{}, errors.New("db error")).Once()
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(50), "123").
+					Return(newMockRow(int64(0), errors.New("db error"))).Once()
 			},
 			mockLoggerFunc: func() {
 				mockLogger.On("ErrorCtx", mock.Anything, "func withdraw sql query failed").Return().Once()
 			},
 			expectedErr: errors.New("db error"),
 		},
+		{
+			name:     "Commit Error",
+			walletID: "123",
+			amount:   50,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(50), "123").
+					Return(newMockRow(int64(150), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "123", WITHDRAW, int64(50), int64(150), "", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(errors.New("commit error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func withdraw commit failed").Return().Once()
+			},
+			expectedErr: errors.New("commit error"),
+		},
+		{
+			name:     "Commit Timeout",
+			walletID: "123",
+			amount:   50,
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockWalletQuery, "123").Return(newWalletRow(200, ACTIVE)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, int64(50), "123").
+					Return(newMockRow(int64(150), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "123", WITHDRAW, int64(50), int64(150), "", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(context.DeadlineExceeded).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func withdraw commit failed").Return().Once()
+			},
+			expectedErr: fmt.Errorf("%w: %w", errCommitUncertain, context.DeadlineExceeded),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup()
+			mockTx := new(MockTx)
+			mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+			mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+			tt.mockSetup(mockTx)
+			tt.mockLoggerFunc()
+
+			err := repo.Withdraw(tt.walletID, tt.amount, tt.currency, context.Background())
+
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockPool.AssertExpectations(t)
+			mockTx.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_Transfer(t *testing.T) {
+	mockLogger := new(MockLogger)
+
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		lockQuery   = "SELECT w.id = $1, w.balance - " + heldAmountSQL + ", w.status, w.currency FROM wallets w WHERE w.id IN ($1, $2) ORDER BY w.id FOR UPDATE"
+		debitQuery  = "UPDATE wallets SET balance = balance - $1 WHERE id = $2 RETURNING balance"
+		creditQuery = "UPDATE wallets SET balance = balance + $1 WHERE id = $2 RETURNING balance"
+	)
+
+	tests := []struct {
+		name           string
+		currency       string
+		mockSetup      func(tx *MockTx)
+		mockLoggerFunc func()
+		expectedErr    error
+	}{
+		{
+			name: "Successful Transfer",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return(&mockRows{rows: [][]any{{false, int64(10), ACTIVE, "RUB"}, {true, int64(100), ACTIVE, "RUB"}}}, nil).Once()
+				tx.On("QueryRow", mock.Anything, debitQuery, int64(60), "from").
+					Return(newMockRow(int64(40), nil)).Once()
+				tx.On("QueryRow", mock.Anything, creditQuery, int64(60), "to").
+					Return(newMockRow(int64(70), nil)).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "from", TRANSFER_OUT, int64(60), int64(40), "to", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Exec", mock.Anything, insertTransactionQuery, "to", TRANSFER_IN, int64(60), int64(70), "from", "", "", "").
+					Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {
+
+			},
+			expectedErr: nil,
+		},
+		{
+			name: "Wallet Not Found",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return(&mockRows{rows: [][]any{{true, int64(100), ACTIVE, "RUB"}}}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func transfer walletid not found").Return().Once()
+			},
+			expectedErr: errWalletid,
+		},
+		{
+			name: "Insufficient Funds",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return(&mockRows{rows: [][]any{{true, int64(50), ACTIVE, "RUB"}, {false, int64(0), ACTIVE, "RUB"}}}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func transfer insufficient funds").Return().Once()
+			},
+			expectedErr: errInsufficientFunds,
+		},
+		{
+			name: "Wallets In Different Currencies",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return(&mockRows{rows: [][]any{{true, int64(100), ACTIVE, "RUB"}, {false, int64(0), ACTIVE, "USD"}}}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func transfer currency mismatch").Return().Once()
+			},
+			expectedErr: errCurrencyMismatch,
+		},
+		{
+			name:     "Request Currency Mismatch",
+			currency: "EUR",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return(&mockRows{rows: [][]any{{true, int64(100), ACTIVE, "RUB"}, {false, int64(0), ACTIVE, "RUB"}}}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func transfer currency mismatch").Return().Once()
+			},
+			expectedErr: errCurrencyMismatch,
+		},
+		{
+			name: "Target Wallet Closed",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return(&mockRows{rows: [][]any{{true, int64(100), ACTIVE, "RUB"}, {false, int64(0), CLOSED, "RUB"}}}, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func transfer wallet closed").Return().Once()
+			},
+			expectedErr: errWalletClosed,
+		},
+		{
+			name: "Database Error",
+			mockSetup: func(tx *MockTx) {
+				tx.On("Query", mock.Anything, lockQuery, "from", "to").
+					Return((*mockRows)(nil), errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func transfer lock wallets failed").Return().Once()
+			},
+			expectedErr: errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTx := new(MockTx)
+			mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+			mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+			tt.mockSetup(mockTx)
 			tt.mockLoggerFunc()
 
-			err := repo.Withdraw(tt.walletID, tt.amount, context.Background())
+			err := repo.Transfer("from", "to", 60, tt.currency, context.Background())
 
 			if tt.expectedErr != nil {
 				assert.Error(t, err)
@@ -198,6 +586,7 @@ func TestRepository_Withdraw(t *testing.T) {
 			}
 
 			mockPool.AssertExpectations(t)
+			mockTx.AssertExpectations(t)
 			mockLogger.AssertExpectations(t)
 		})
 	}
@@ -207,13 +596,14 @@ func TestRepository_GetBalance(t *testing.T) {
 	mockLogger := new(MockLogger)
 
 	mockPool := new(MockPool)
-	repo := &Repository{db: mockPool, lg: mockLogger, ctx: context.Background()}
+	repo := &Repository{db: mockPool, lg: mockLogger}
+	const balanceQuery = "SELECT w.balance, w.balance - " + heldAmountSQL + ", w.currency FROM wallets w WHERE w.id = $1"
 
 	tests := []struct {
 		name            string
 		walletID        string
 		mockSetup       func()
-		expectedBalance int64
+		expectedBalance Balance
 		expectedErr     error
 		mockLoggerFunc  func()
 	}{
@@ -221,39 +611,39 @@ func TestRepository_GetBalance(t *testing.T) {
 			name:     "Successful Get Balance",
 			walletID: "123",
 			mockSetup: func() {
-				mockPool.On("QueryRow", mock.Anything, "SELECT balance FROM wallets WHERE id = $1", "123").
-					Return(newMockRow(int64(100), nil)).Once()
+				mockPool.On("QueryRow", mock.Anything, balanceQuery, "123").
+					Return(&mockRow{values: []any{int64(100), int64(60), "KWD"}}).Once()
 			},
 			mockLoggerFunc: func() {
 
 			},
-			expectedBalance: 100,
+			expectedBalance: Balance{Ledger: 100, Available: 60, Currency: "KWD", MinorUnits: 3},
 			expectedErr:     nil,
 		},
 		{
 			name:     "Wallet Not Found",
 			walletID: "123",
 			mockSetup: func() {
-				mockPool.On("QueryRow", mock.Anything, "SELECT balance FROM wallets WHERE id = $1", "123").
+				mockPool.On("QueryRow", mock.Anything, balanceQuery, "123").
 					Return(newMockRow(int64(0), pgx.ErrNoRows)).Once()
 			},
 			mockLoggerFunc: func() {
 				mockLogger.On("ErrorCtx", mock.Anything, "func getbalance walletid not found").Return().Once()
 			},
-			expectedBalance: 0,
+			expectedBalance: Balance{},
 			expectedErr:     errWalletid,
 		},
 		{
 			name:     "Database Error",
 			walletID: "123",
 			mockSetup: func() {
-				mockPool.On("QueryRow", mock.Anything, "SELECT balance FROM wallets WHERE id = $1", "123").
+				mockPool.On("QueryRow", mock.Anything, balanceQuery, "123").
 					Return(newMockRow(int64(0), errors.New("db error"))).Once()
 			},
 			mockLoggerFunc: func() {
 				mockLogger.On("ErrorCtx", mock.Anything, "Could not scan wallet").Return().Once()
 			},
-			expectedBalance: 0,
+			expectedBalance: Balance{},
 			expectedErr:     errors.New("db error"),
 		},
 	}
@@ -279,3 +669,119 @@ func TestRepository_GetBalance(t *testing.T) {
 		})
 	}
 }
+
+func TestRepository_GetTransactions(t *testing.T) {
+	mockLogger := new(MockLogger)
+
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		baseQuery  = "SELECT id, wallet_id::text, operation_type, amount, balance_after, COALESCE(counterparty_wallet_id::text, ''), COALESCE(request_id, ''), created_at FROM wallet_transactions WHERE wallet_id = $1"
+		countQuery = "SELECT count(*) FROM wallets WHERE id = $1"
+	)
+	createdAt := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC)
+	minAmount := int64(10)
+
+	tests := []struct {
+		name                 string
+		walletID             string
+		filter               TransactionFilter
+		mockSetup            func()
+		expectedTransactions []Transaction
+		expectedErr          error
+		mockLoggerFunc       func()
+	}{
+		{
+			name:     "Successful Get Transactions",
+			walletID: "123",
+			filter:   TransactionFilter{Limit: 3},
+			mockSetup: func() {
+				mockPool.On("Query", mock.Anything, baseQuery+" ORDER BY id DESC LIMIT $2", "123", 3).
+					Return(newTransactionRows([]Transaction{
+						{ID: 2, WalletID: "123", OperationType: WITHDRAW, Amount: 40, BalanceAfter: 60, RequestID: "req-2", CreatedAt: createdAt},
+						{ID: 1, WalletID: "123", OperationType: DEPOSIT, Amount: 100, BalanceAfter: 100, RequestID: "req-1", CreatedAt: createdAt},
+					}), nil).Once()
+			},
+			mockLoggerFunc: func() {
+
+			},
+			expectedTransactions: []Transaction{
+				{ID: 2, WalletID: "123", OperationType: WITHDRAW, Amount: 40, BalanceAfter: 60, RequestID: "req-2", CreatedAt: createdAt},
+				{ID: 1, WalletID: "123", OperationType: DEPOSIT, Amount: 100, BalanceAfter: 100, RequestID: "req-1", CreatedAt: createdAt},
+			},
+			expectedErr: nil,
+		},
+		{
+			name:     "Filtered Get Transactions",
+			walletID: "123",
+			filter:   TransactionFilter{Cursor: 7, OperationType: DEPOSIT, From: createdAt, MinAmount: &minAmount, Limit: 5},
+			mockSetup: func() {
+				mockPool.On("Query", mock.Anything,
+					baseQuery+" AND id < $2 AND operation_type = $3 AND created_at >= $4 AND amount >= $5 ORDER BY id DESC LIMIT $6",
+					"123", int64(7), DEPOSIT, createdAt, int64(10), 5).
+					Return(newTransactionRows([]Transaction{
+						{ID: 5, WalletID: "123", OperationType: DEPOSIT, Amount: 100, BalanceAfter: 100, CreatedAt: createdAt},
+					}), nil).Once()
+			},
+			mockLoggerFunc: func() {
+
+			},
+			expectedTransactions: []Transaction{
+				{ID: 5, WalletID: "123", OperationType: DEPOSIT, Amount: 100, BalanceAfter: 100, CreatedAt: createdAt},
+			},
+			expectedErr: nil,
+		},
+		{
+			name:     "Wallet Not Found",
+			walletID: "123",
+			filter:   TransactionFilter{},
+			mockSetup: func() {
+				mockPool.On("Query", mock.Anything, baseQuery+" ORDER BY id DESC", "123").
+					Return(newTransactionRows(nil), nil).Once()
+				mockPool.On("QueryRow", mock.Anything, countQuery, "123").
+					Return(newMockRow(int64(0), nil)).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func gettransactions walletid not found").Return().Once()
+			},
+			expectedTransactions: nil,
+			expectedErr:          errWalletid,
+		},
+		{
+			name:     "Database Error",
+			walletID: "123",
+			filter:   TransactionFilter{},
+			mockSetup: func() {
+				mockPool.On("Query", mock.Anything, baseQuery+" ORDER BY id DESC", "123").
+					Return((*mockRows)(nil), errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "func gettransactions sql query failed").Return().Once()
+			},
+			expectedTransactions: nil,
+			expectedErr:          errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+			tt.mockLoggerFunc()
+
+			transactions, err := repo.GetTransactions(tt.walletID, tt.filter, context.Background())
+
+			assert.Equal(t, tt.expectedTransactions, transactions)
+
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockPool.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
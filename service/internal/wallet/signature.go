@@ -0,0 +1,168 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"service/internal/config"
+	"service/internal/requestctx"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+
+	defaultSignatureSkew = config.DefaultSignatureSkew
+	noncePurgePeriod     = time.Hour
+	maxNonceLength       = 64
+)
+
+var (
+	errInvalidSignature = errors.New("missing or invalid request signature")
+	errStaleSignature   = errors.New("request timestamp is outside the allowed clock skew")
+	errRequestReplayed  = errors.New("request was already received")
+)
+
+// signRequest returns the hex HMAC-SHA256 under secret of the method, path, timestamp,
+// nonce and idempotency key, each followed by a newline, and the body.
+func signRequest(secret, method, path, timestamp, nonce, idempotencyKey string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + idempotencyKey + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature requires clients with a signing secret to sign their requests.
+// X-Timestamp is the Unix time of the request, in seconds, X-Nonce a unique value of
+// up to 64 characters and X-Signature the hex HMAC-SHA256 of
+// "<method>\n<path>\n<X-Timestamp>\n<X-Nonce>\n<Idempotency-Key>\n<body>", with an
+// empty line when there is no Idempotency-Key. Requests older or newer than the clock
+// skew are rejected. A request with an Idempotency-Key is protected from replay by the
+// key, which config.Validate keeps for at least twice the skew, so that a retry gets
+// the stored response; any other request is accepted once per nonce. Clients without a secret, and end users, are passed through.
+func (h *Handler) VerifySignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		client, ok := apiClientFromContext(ctx)
+		if !ok || client.SigningSecret == "" {
+			next(w, r)
+			return
+		}
+
+		signature := r.Header.Get(SignatureHeader)
+		timestamp := r.Header.Get(TimestampHeader)
+		nonce := r.Header.Get(NonceHeader)
+		if signature == "" || timestamp == "" || nonce == "" {
+			h.lg.ErrorCtx(ctx, "missing request signature")
+			writeError(w, r, errInvalidSignature)
+			return
+		}
+		if len(nonce) > maxNonceLength {
+			h.lg.ErrorCtx(ctx, "request nonce is too long")
+			writeError(w, r, errInvalidSignature)
+			return
+		}
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			h.lg.ErrorCtx(ctx, "invalid request timestamp")
+			writeError(w, r, errInvalidSignature)
+			return
+		}
+		skew := h.signatureSkew
+		if skew <= 0 {
+			skew = defaultSignatureSkew
+		}
+		if age := time.Since(time.Unix(sent, 0)); age > skew || age < -skew {
+			h.lg.ErrorCtx(ctx, fmt.Sprintf("stale request timestamp = %s", timestamp))
+			writeError(w, r, errStaleSignature)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+		if err != nil {
+			h.lg.ErrorCtx(ctx, "error read request body")
+			writeDecodeError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+		expected := signRequest(client.SigningSecret, r.Method, r.URL.Path, timestamp, nonce, idempotencyKey, body)
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			h.lg.ErrorCtx(ctx, "invalid request signature")
+			writeError(w, r, errInvalidSignature)
+			return
+		}
+		if idempotencyKey != "" {
+			next(w, r)
+			return
+		}
+
+		// A nonce could be replayed until its timestamp leaves the skew window, so it
+		// is remembered for twice the skew.
+		clientID, _ := requestctx.ClientIDFromContext(ctx)
+		if err := h.repo.UseNonce(clientID, nonce, 2*skew, ctx); err == errRequestReplayed {
+			h.lg.ErrorCtx(ctx, "replayed request")
+			writeError(w, r, err)
+			return
+		} else if err != nil {
+			h.lg.ErrorCtx(ctx, "error recording request nonce")
+			writeError(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// purgeNonces deletes expired nonces until the handler is closed.
+func (h *Handler) purgeNonces(ctx context.Context) {
+	ticker := time.NewTicker(noncePurgePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			purged, err := h.repo.PurgeNonces(ctx)
+			if err != nil {
+				h.lg.ErrorCtx(ctx, "error purging request nonces")
+				continue
+			}
+			h.lg.DebugCtx(ctx, fmt.Sprintf("purged request nonces = %d", purged))
+		}
+	}
+}
+
+// UseNonce records nonce for the client. It returns errRequestReplayed when the
+// nonce was already recorded and has not expired.
+func (r *Repository) UseNonce(clientID, nonce string, ttl time.Duration, ctx context.Context) error {
+	result, err := r.db.Exec(ctx, `INSERT INTO request_nonces (client_id, nonce, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+ON CONFLICT (client_id, nonce) DO UPDATE SET expires_at = EXCLUDED.expires_at
+WHERE request_nonces.expires_at < now()`, clientID, nonce, ttl.Seconds())
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func usenonce sql query failed")
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errRequestReplayed
+	}
+	return nil
+}
+
+func (r *Repository) PurgeNonces(ctx context.Context) (int64, error) {
+	result, err := r.db.Exec(ctx, "DELETE FROM request_nonces WHERE expires_at < now()")
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func purgenonces sql query failed")
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
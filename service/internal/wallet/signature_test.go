@@ -0,0 +1,295 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/problem"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestVerifySignature(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger, signatureSkew: time.Minute}
+
+	partner := &APIClient{ID: "client-3", Scopes: []string{SCOPE_DEPOSIT}, SigningSecret: "shared-secret"}
+	unsigned := &APIClient{ID: "client-4", Scopes: []string{SCOPE_DEPOSIT}}
+	body := `{"walletId":"` + walletA + `","operationType":"DEPOSIT","amount":100,"currency":"RUB"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	sign := func(secret, timestamp, nonce, key string) string {
+		return signRequest(secret, http.MethodPost, "/api/v1/wallet", timestamp, nonce, key, []byte(body))
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		// The body must still be readable after verification.
+		got, _ := io.ReadAll(r.Body)
+		w.Write(got)
+	}
+
+	tests := []struct {
+		name           string
+		client         *APIClient
+		timestamp      string
+		nonce          string
+		idempotencyKey string
+		signature      string
+		expectedStatus int
+		expectedCode   string
+		mockRepoFunc   func()
+		mockLoggerFunc func()
+	}{
+		{
+			name:           "Valid Signature",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			signature:      sign("shared-secret", now, "nonce-1", ""),
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("UseNonce", partner.ID, "nonce-1", 2*time.Minute, mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {},
+		},
+		{
+			name:           "Same Operation With Another Nonce",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-2",
+			signature:      sign("shared-secret", now, "nonce-2", ""),
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("UseNonce", partner.ID, "nonce-2", 2*time.Minute, mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {},
+		},
+		{
+			name:           "Idempotency Key Skips Nonce",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			idempotencyKey: "retry-1",
+			signature:      sign("shared-secret", now, "nonce-1", "retry-1"),
+			expectedStatus: http.StatusOK,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {},
+		},
+		{
+			name:           "Client Without Secret",
+			client:         unsigned,
+			expectedStatus: http.StatusOK,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {},
+		},
+		{
+			name:           "Missing Signature",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "missing request signature").Return().Once()
+			},
+		},
+		{
+			name:           "Missing Nonce",
+			client:         partner,
+			timestamp:      now,
+			signature:      sign("shared-secret", now, "", ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "missing request signature").Return().Once()
+			},
+		},
+		{
+			name:           "Nonce Too Long",
+			client:         partner,
+			timestamp:      now,
+			nonce:          strings.Repeat("n", maxNonceLength+1),
+			signature:      sign("shared-secret", now, strings.Repeat("n", maxNonceLength+1), ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "request nonce is too long").Return().Once()
+			},
+		},
+		{
+			name:           "Invalid Timestamp",
+			client:         partner,
+			timestamp:      "yesterday",
+			nonce:          "nonce-1",
+			signature:      sign("shared-secret", "yesterday", "nonce-1", ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request timestamp").Return().Once()
+			},
+		},
+		{
+			name:           "Stale Timestamp",
+			client:         partner,
+			timestamp:      stale,
+			nonce:          "nonce-1",
+			signature:      sign("shared-secret", stale, "nonce-1", ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "stale request timestamp = "+stale).Return().Once()
+			},
+		},
+		{
+			name:           "Wrong Secret",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			signature:      sign("guessed-secret", now, "nonce-1", ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request signature").Return().Once()
+			},
+		},
+		{
+			name:           "Nonce Not Signed",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-3",
+			signature:      sign("shared-secret", now, "nonce-1", ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request signature").Return().Once()
+			},
+		},
+		{
+			name:           "Signed For Another Path",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			signature:      signRequest("shared-secret", http.MethodPost, "/api/v1/wallet/batch", now, "nonce-1", "", []byte(body)),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request signature").Return().Once()
+			},
+		},
+		{
+			name:           "Idempotency Key Not Signed",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			idempotencyKey: "retry-2",
+			signature:      sign("shared-secret", now, "nonce-1", "retry-1"),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   problem.InvalidSignature,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "invalid request signature").Return().Once()
+			},
+		},
+		{
+			name:           "Replayed Request",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			signature:      sign("shared-secret", now, "nonce-1", ""),
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.RequestReplayed,
+			mockRepoFunc: func() {
+				mockRepo.On("UseNonce", partner.ID, "nonce-1", 2*time.Minute, mock.Anything).Return(errRequestReplayed).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "replayed request").Return().Once()
+			},
+		},
+		{
+			name:           "Database Error",
+			client:         partner,
+			timestamp:      now,
+			nonce:          "nonce-1",
+			signature:      sign("shared-secret", now, "nonce-1", ""),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.InternalError,
+			mockRepoFunc: func() {
+				mockRepo.On("UseNonce", partner.ID, "nonce-1", 2*time.Minute, mock.Anything).Return(errors.New("db error")).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "error recording request nonce").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := withClient(httptest.NewRequest(http.MethodPost, "/api/v1/wallet", strings.NewReader(body)), tt.client)
+			for header, value := range map[string]string{
+				TimestampHeader:      tt.timestamp,
+				NonceHeader:          tt.nonce,
+				IdempotencyKeyHeader: tt.idempotencyKey,
+				SignatureHeader:      tt.signature,
+			} {
+				if value != "" {
+					req.Header.Set(header, value)
+				}
+			}
+			w := httptest.NewRecorder()
+			handler.VerifySignature(next)(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedCode != "" {
+				var p problem.Problem
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+				assert.Equal(t, tt.expectedCode, p.Code)
+			} else {
+				assert.Equal(t, body, w.Body.String())
+			}
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_UseNonce(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const query = `INSERT INTO request_nonces (client_id, nonce, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+ON CONFLICT (client_id, nonce) DO UPDATE SET expires_at = EXCLUDED.expires_at
+WHERE request_nonces.expires_at < now()`
+
+	mockPool.On("Exec", mock.Anything, query, "client-3", "nonce", float64(600)).Return(pgconn.NewCommandTag("INSERT 0 1"), nil).Once()
+	assert.NoError(t, repo.UseNonce("client-3", "nonce", 10*time.Minute, context.Background()))
+
+	mockPool.On("Exec", mock.Anything, query, "client-3", "nonce", float64(600)).Return(pgconn.NewCommandTag("INSERT 0 0"), nil).Once()
+	assert.Equal(t, errRequestReplayed, repo.UseNonce("client-3", "nonce", 10*time.Minute, context.Background()))
+
+	mockPool.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
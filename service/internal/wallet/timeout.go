@@ -0,0 +1,167 @@
+package wallet
+
+import (
+	"context"
+	"time"
+)
+
+// timeoutRepository bounds every repository call by a deadline, so a slow database
+// fails the request with a 503 instead of holding its goroutine and connection.
+type timeoutRepository struct {
+	next    RepositoryInterface
+	timeout time.Duration
+}
+
+// newTimeoutRepository wraps next; a non-positive timeout returns next unchanged.
+func newTimeoutRepository(next RepositoryInterface, timeout time.Duration) RepositoryInterface {
+	if timeout <= 0 {
+		return next
+	}
+	return &timeoutRepository{next: next, timeout: timeout}
+}
+
+func (t *timeoutRepository) Deposit(walletID string, amount int64, currency string, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.Deposit(walletID, amount, currency, ctx)
+}
+
+func (t *timeoutRepository) Withdraw(walletID string, amount int64, currency string, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.Withdraw(walletID, amount, currency, ctx)
+}
+
+func (t *timeoutRepository) Transfer(fromWalletID, toWalletID string, amount int64, currency string, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.Transfer(fromWalletID, toWalletID, amount, currency, ctx)
+}
+
+func (t *timeoutRepository) GetBalance(walletID string, ctx context.Context) (Balance, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.GetBalance(walletID, ctx)
+}
+
+func (t *timeoutRepository) CreateWallet(ownerID, externalRef, currency string, ctx context.Context) (*Wallet, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.CreateWallet(ownerID, externalRef, currency, ctx)
+}
+
+func (t *timeoutRepository) GetWallet(walletID string, ctx context.Context) (*Wallet, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.GetWallet(walletID, ctx)
+}
+
+func (t *timeoutRepository) GetWalletOwners(walletIDs []string, ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.GetWalletOwners(walletIDs, ctx)
+}
+
+func (t *timeoutRepository) CloseWallet(walletID string, ctx context.Context) (*Wallet, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.CloseWallet(walletID, ctx)
+}
+
+func (t *timeoutRepository) ApplyBatch(operations []WalletOperationRequest, atomic bool, ctx context.Context) ([]error, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.ApplyBatch(operations, atomic, ctx)
+}
+
+func (t *timeoutRepository) CreateHold(walletID string, amount int64, currency string, ttl time.Duration, ctx context.Context) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.CreateHold(walletID, amount, currency, ttl, ctx)
+}
+
+func (t *timeoutRepository) CaptureHold(walletID, holdID string, amount int64, ctx context.Context) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.CaptureHold(walletID, holdID, amount, ctx)
+}
+
+func (t *timeoutRepository) VoidHold(walletID, holdID string, ctx context.Context) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.VoidHold(walletID, holdID, ctx)
+}
+
+func (t *timeoutRepository) ExpireHolds(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.ExpireHolds(ctx)
+}
+
+func (t *timeoutRepository) GetTransactions(walletID string, filter TransactionFilter, ctx context.Context) ([]Transaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.GetTransactions(walletID, filter, ctx)
+}
+
+func (t *timeoutRepository) ReserveIdempotencyKey(principal, key, requestHash string, ttl, lease time.Duration, ctx context.Context) (*IdempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.ReserveIdempotencyKey(principal, key, requestHash, ttl, lease, ctx)
+}
+
+func (t *timeoutRepository) SaveIdempotentResponse(principal, key string, response IdempotentResponse, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.SaveIdempotentResponse(principal, key, response, ctx)
+}
+
+func (t *timeoutRepository) ReleaseIdempotencyKey(principal, key string, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.ReleaseIdempotencyKey(principal, key, ctx)
+}
+
+func (t *timeoutRepository) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.PurgeIdempotencyKeys(ctx)
+}
+
+func (t *timeoutRepository) GetAPIClient(keyHash string, ctx context.Context) (*APIClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.GetAPIClient(keyHash, ctx)
+}
+
+func (t *timeoutRepository) UseNonce(clientID, nonce string, ttl time.Duration, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.UseNonce(clientID, nonce, ttl, ctx)
+}
+
+func (t *timeoutRepository) PurgeNonces(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.PurgeNonces(ctx)
+}
+
+func (t *timeoutRepository) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.Ping(ctx)
+}
+
+func (t *timeoutRepository) SchemaVersion(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.next.SchemaVersion(ctx)
+}
+
+func (t *timeoutRepository) PoolStats() PoolStats {
+	return t.next.PoolStats()
+}
+
+func (t *timeoutRepository) Close() {
+	t.next.Close()
+}
@@ -0,0 +1,25 @@
+package wallet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTimeoutRepository(t *testing.T) {
+	mockRepo := new(MockRepository)
+	repo := newTimeoutRepository(mockRepo, time.Second)
+
+	hasDeadline := mock.MatchedBy(func(ctx context.Context) bool {
+		deadline, ok := ctx.Deadline()
+		return ok && time.Until(deadline) <= time.Second
+	})
+	mockRepo.On("Deposit", walletA, int64(100), "", hasDeadline).Return(nil).Once()
+
+	assert.NoError(t, repo.Deposit(walletA, 100, "", context.Background()))
+	assert.Same(t, mockRepo, newTimeoutRepository(mockRepo, 0))
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,183 @@
+package wallet
+
+import (
+	"context"
+	"service/internal/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	walletIDKey      = attribute.Key("wallet.id")
+	operationTypeKey = attribute.Key("wallet.operation_type")
+	amountKey        = attribute.Key("wallet.amount")
+)
+
+// tracedRepository wraps every repository call in a span. The SQL statements it
+// runs show up as child spans through tracing.QueryTracer.
+type tracedRepository struct {
+	next RepositoryInterface
+}
+
+func newTracedRepository(next RepositoryInterface) RepositoryInterface {
+	return &tracedRepository{next: next}
+}
+
+func (t *tracedRepository) Deposit(walletID string, amount int64, currency string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.Deposit")
+	span.SetAttributes(walletIDKey.String(walletID), amountKey.Int64(amount))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Deposit(walletID, amount, currency, ctx)
+}
+
+func (t *tracedRepository) Withdraw(walletID string, amount int64, currency string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.Withdraw")
+	span.SetAttributes(walletIDKey.String(walletID), amountKey.Int64(amount))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Withdraw(walletID, amount, currency, ctx)
+}
+
+func (t *tracedRepository) Transfer(fromWalletID, toWalletID string, amount int64, currency string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.Transfer")
+	span.SetAttributes(walletIDKey.String(fromWalletID), attribute.String("wallet.target_id", toWalletID), amountKey.Int64(amount))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Transfer(fromWalletID, toWalletID, amount, currency, ctx)
+}
+
+func (t *tracedRepository) GetBalance(walletID string, ctx context.Context) (balance Balance, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetBalance")
+	span.SetAttributes(walletIDKey.String(walletID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetBalance(walletID, ctx)
+}
+
+func (t *tracedRepository) CreateWallet(ownerID, externalRef, currency string, ctx context.Context) (wallet *Wallet, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CreateWallet")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateWallet(ownerID, externalRef, currency, ctx)
+}
+
+func (t *tracedRepository) GetWallet(walletID string, ctx context.Context) (wallet *Wallet, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetWallet")
+	span.SetAttributes(walletIDKey.String(walletID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetWallet(walletID, ctx)
+}
+
+func (t *tracedRepository) GetWalletOwners(walletIDs []string, ctx context.Context) (owners map[string]string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetWalletOwners")
+	span.SetAttributes(attribute.Int("wallet.count", len(walletIDs)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetWalletOwners(walletIDs, ctx)
+}
+
+func (t *tracedRepository) CloseWallet(walletID string, ctx context.Context) (wallet *Wallet, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CloseWallet")
+	span.SetAttributes(walletIDKey.String(walletID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CloseWallet(walletID, ctx)
+}
+
+func (t *tracedRepository) ApplyBatch(operations []WalletOperationRequest, atomic bool, ctx context.Context) (results []error, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.ApplyBatch")
+	span.SetAttributes(attribute.Int("wallet.batch.size", len(operations)), attribute.Bool("wallet.batch.atomic", atomic))
+	defer func() { tracing.End(span, err) }()
+	return t.next.ApplyBatch(operations, atomic, ctx)
+}
+
+func (t *tracedRepository) CreateHold(walletID string, amount int64, currency string, ttl time.Duration, ctx context.Context) (hold *Hold, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CreateHold")
+	span.SetAttributes(walletIDKey.String(walletID), amountKey.Int64(amount))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateHold(walletID, amount, currency, ttl, ctx)
+}
+
+func (t *tracedRepository) CaptureHold(walletID, holdID string, amount int64, ctx context.Context) (hold *Hold, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CaptureHold")
+	span.SetAttributes(walletIDKey.String(walletID), attribute.String("wallet.hold_id", holdID), amountKey.Int64(amount))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CaptureHold(walletID, holdID, amount, ctx)
+}
+
+func (t *tracedRepository) VoidHold(walletID, holdID string, ctx context.Context) (hold *Hold, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.VoidHold")
+	span.SetAttributes(walletIDKey.String(walletID), attribute.String("wallet.hold_id", holdID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.VoidHold(walletID, holdID, ctx)
+}
+
+func (t *tracedRepository) ExpireHolds(ctx context.Context) (expired int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.ExpireHolds")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ExpireHolds(ctx)
+}
+
+func (t *tracedRepository) GetTransactions(walletID string, filter TransactionFilter, ctx context.Context) (transactions []Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTransactions")
+	span.SetAttributes(walletIDKey.String(walletID), operationTypeKey.String(filter.OperationType))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetTransactions(walletID, filter, ctx)
+}
+
+func (t *tracedRepository) ReserveIdempotencyKey(principal, key, requestHash string, ttl, lease time.Duration, ctx context.Context) (response *IdempotentResponse, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.ReserveIdempotencyKey")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ReserveIdempotencyKey(principal, key, requestHash, ttl, lease, ctx)
+}
+
+func (t *tracedRepository) SaveIdempotentResponse(principal, key string, response IdempotentResponse, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SaveIdempotentResponse")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SaveIdempotentResponse(principal, key, response, ctx)
+}
+
+func (t *tracedRepository) ReleaseIdempotencyKey(principal, key string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.ReleaseIdempotencyKey")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ReleaseIdempotencyKey(principal, key, ctx)
+}
+
+func (t *tracedRepository) PurgeIdempotencyKeys(ctx context.Context) (purged int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.PurgeIdempotencyKeys")
+	defer func() { tracing.End(span, err) }()
+	return t.next.PurgeIdempotencyKeys(ctx)
+}
+
+func (t *tracedRepository) GetAPIClient(keyHash string, ctx context.Context) (client *APIClient, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAPIClient")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetAPIClient(keyHash, ctx)
+}
+
+func (t *tracedRepository) UseNonce(clientID, nonce string, ttl time.Duration, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.UseNonce")
+	defer func() { tracing.End(span, err) }()
+	return t.next.UseNonce(clientID, nonce, ttl, ctx)
+}
+
+func (t *tracedRepository) PurgeNonces(ctx context.Context) (purged int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.PurgeNonces")
+	defer func() { tracing.End(span, err) }()
+	return t.next.PurgeNonces(ctx)
+}
+
+func (t *tracedRepository) Ping(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.Ping")
+	defer func() { tracing.End(span, err) }()
+	return t.next.Ping(ctx)
+}
+
+func (t *tracedRepository) SchemaVersion(ctx context.Context) (version int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SchemaVersion")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SchemaVersion(ctx)
+}
+
+func (t *tracedRepository) PoolStats() PoolStats {
+	return t.next.PoolStats()
+}
+
+func (t *tracedRepository) Close() {
+	t.next.Close()
+}
@@ -0,0 +1,106 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"service/internal/problem"
+	"strings"
+
+	guid "github.com/satori/go.uuid"
+)
+
+const (
+	defaultMaxOperationAmount = 10_000_000
+	maxOperationBodySize      = 4 << 10
+	maxRequestBodySize        = 1 << 20
+)
+
+// Validate checks the request before it reaches the repository and reports
+// every invalid field at once. maxAmount is given in major units and applied in
+// minor units of the request currency; zero disables the upper bound.
+// Valid wallet IDs are rewritten to the canonical form, since Postgres does not
+// accept every spelling of a UUID that guid.FromString does.
+func (req *WalletOperationRequest) Validate(maxAmount int64) []problem.FieldError {
+	var errs []problem.FieldError
+	add := func(field, message string) {
+		errs = append(errs, problem.FieldError{Field: field, Message: message})
+	}
+
+	walletID, err := guid.FromString(req.WalletID)
+	if err != nil {
+		add("walletId", "must be a valid UUID")
+	} else {
+		req.WalletID = walletID.String()
+	}
+
+	switch req.OperationType {
+	case DEPOSIT, WITHDRAW:
+		if req.TargetWalletID != "" {
+			add("targetWalletId", "is only allowed for TRANSFER")
+		}
+	case TRANSFER:
+		if targetID, err := guid.FromString(req.TargetWalletID); err != nil {
+			add("targetWalletId", "must be a valid UUID")
+		} else if guid.Equal(walletID, targetID) {
+			add("targetWalletId", "must differ from walletId")
+		} else {
+			req.TargetWalletID = targetID.String()
+		}
+	default:
+		add("operationType", fmt.Sprintf("must be one of %s, %s, %s", DEPOSIT, WITHDRAW, TRANSFER))
+	}
+
+	if req.Amount <= 0 {
+		add("amount", "must be positive")
+	} else if limit := maxAmountIn(req.Currency, maxAmount); limit > 0 && validCurrency(req.Currency) && req.Amount > limit {
+		add("amount", fmt.Sprintf("must not exceed %d", limit))
+	}
+
+	if req.Currency == "" {
+		add("currency", "is required")
+	} else if !validCurrency(req.Currency) {
+		add("currency", "must be a supported ISO 4217 currency code")
+	}
+	return errs
+}
+
+// writeValidation replies with the field errors of a wallet operation. An unknown
+// operation type is reported as INVALID_OPERATION, the other errors as
+// VALIDATION_FAILED.
+func writeValidation(w http.ResponseWriter, r *http.Request, errs []problem.FieldError) {
+	for _, e := range errs {
+		if e.Field == "operationType" || strings.HasSuffix(e.Field, ".operationType") {
+			p := problem.New(r, http.StatusBadRequest, problem.InvalidOperation, "unknown operation type")
+			p.Errors = errs
+			p.Write(w)
+			return
+		}
+	}
+	problem.WriteValidation(w, r, errs)
+}
+
+var errTrailingData = errors.New("request body must contain a single JSON object")
+
+// decodeJSON strictly decodes a body of at most limit bytes into dst.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, dst any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errTrailingData
+	}
+	return nil
+}
+
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		problem.Write(w, r, http.StatusRequestEntityTooLarge, problem.RequestTooLarge, fmt.Sprintf("request body must not exceed %d bytes", maxBytesErr.Limit))
+		return
+	}
+	problem.Write(w, r, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+}
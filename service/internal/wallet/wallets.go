@@ -0,0 +1,269 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"service/internal/problem"
+	"service/internal/requestctx"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	guid "github.com/satori/go.uuid"
+)
+
+const (
+	ACTIVE string = "ACTIVE"
+	CLOSED string = "CLOSED"
+)
+
+const (
+	uniqueViolation     = "23505"
+	maxWalletTextLength = 255
+)
+
+var (
+	errWalletClosed      = errors.New("wallet is closed")
+	errWalletNotEmpty    = errors.New("wallet still holds money")
+	errExternalRefExists = errors.New("wallet with this external reference already exists")
+)
+
+// Wallet is the metadata of a wallet together with its current balance.
+type Wallet struct {
+	ID          string     `json:"id"`
+	OwnerID     string     `json:"ownerId,omitempty"`
+	ExternalRef string     `json:"externalRef,omitempty"`
+	Status      string     `json:"status"`
+	Balance     int64      `json:"balance"`
+	Currency    string     `json:"currency"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ClosedAt    *time.Time `json:"closedAt,omitempty"`
+}
+
+type CreateWalletRequest struct {
+	OwnerID     string `json:"ownerId"`
+	ExternalRef string `json:"externalRef"`
+	Currency    string `json:"currency"`
+}
+
+func (req CreateWalletRequest) Validate() []problem.FieldError {
+	var errs []problem.FieldError
+	if len(req.OwnerID) > maxWalletTextLength {
+		errs = append(errs, problem.FieldError{Field: "ownerId", Message: fmt.Sprintf("must be at most %d characters", maxWalletTextLength)})
+	}
+	if len(req.ExternalRef) > maxWalletTextLength {
+		errs = append(errs, problem.FieldError{Field: "externalRef", Message: fmt.Sprintf("must be at most %d characters", maxWalletTextLength)})
+	}
+	if req.Currency != "" && !validCurrency(req.Currency) {
+		errs = append(errs, problem.FieldError{Field: "currency", Message: "must be a supported ISO 4217 currency code"})
+	}
+	return errs
+}
+
+func (h *Handler) CreateWallet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var request CreateWalletRequest
+	// An empty body creates an anonymous wallet.
+	if err := decodeJSON(w, r, maxOperationBodySize, &request); err != nil && err != io.EOF {
+		h.lg.ErrorCtx(ctx, "error decode request body")
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := request.Validate(); len(errs) > 0 {
+		h.lg.ErrorCtx(ctx, fmt.Sprintf("invalid request: %v", errs))
+		problem.WriteValidation(w, r, errs)
+		return
+	}
+
+	if request.Currency == "" {
+		request.Currency = defaultCurrency
+	}
+
+	wallet, err := h.repo.CreateWallet(request.OwnerID, request.ExternalRef, request.Currency, ctx)
+	if err == errExternalRefExists {
+		h.lg.ErrorCtx(ctx, "external reference already exists")
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		h.lg.ErrorCtx(ctx, "error creating wallet")
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/wallets/"+wallet.ID)
+	writeWallet(w, http.StatusCreated, wallet)
+	h.lg.With("walletId", wallet.ID).InfoCtx(ctx, "wallet created")
+}
+
+func (h *Handler) GetWallet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	walletID, ok := h.walletParam(w, r)
+	if !ok {
+		return
+	}
+	lg := h.lg.With("walletId", walletID)
+	lg.DebugCtx(ctx, "getting wallet")
+
+	wallet, err := h.repo.GetWallet(walletID, ctx)
+	if err == errWalletid {
+		lg.ErrorCtx(ctx, "walletid not found")
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		lg.ErrorCtx(ctx, "error getting wallet")
+		writeError(w, r, err)
+		return
+	}
+
+	writeWallet(w, http.StatusOK, wallet)
+	lg.InfoCtx(ctx, "wallet retrieved")
+}
+
+func (h *Handler) CloseWallet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	walletID, ok := h.walletParam(w, r)
+	if !ok {
+		return
+	}
+	lg := h.lg.With("walletId", walletID)
+	lg.DebugCtx(ctx, "closing wallet")
+
+	wallet, err := h.repo.CloseWallet(walletID, ctx)
+	if err == errWalletid {
+		lg.ErrorCtx(ctx, "walletid not found")
+		writeError(w, r, err)
+		return
+	} else if err == errWalletClosed || err == errWalletNotEmpty {
+		lg.With("error", err).ErrorCtx(ctx, "wallet cannot be closed")
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		lg.ErrorCtx(ctx, "error closing wallet")
+		writeError(w, r, err)
+		return
+	}
+
+	writeWallet(w, http.StatusOK, wallet)
+	lg.InfoCtx(ctx, "wallet closed")
+}
+
+// walletParam returns the {id} of the request in canonical form. An ID that is not a
+// UUID cannot name a wallet, so it is reported as not found.
+func (h *Handler) walletParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	walletID, err := guid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		h.lg.ErrorCtx(r.Context(), "walletid not found")
+		writeError(w, r, errWalletid)
+		return "", false
+	}
+	return walletID.String(), true
+}
+
+func writeWallet(w http.ResponseWriter, status int, wallet *Wallet) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(wallet)
+}
+
+const walletColumns = "id::text, COALESCE(owner_id, ''), COALESCE(external_ref, ''), status, balance, currency, created_at, closed_at"
+
+func scanWallet(row pgx.Row) (*Wallet, error) {
+	wallet := new(Wallet)
+	err := row.Scan(&wallet.ID, &wallet.OwnerID, &wallet.ExternalRef, &wallet.Status, &wallet.Balance, &wallet.Currency, &wallet.CreatedAt, &wallet.ClosedAt)
+	return wallet, err
+}
+
+func (r *Repository) CreateWallet(ownerID, externalRef, currency string, ctx context.Context) (*Wallet, error) {
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	wallet, err := scanWallet(r.db.QueryRow(ctx, "INSERT INTO wallets (owner_id, external_ref, currency, client_id) VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, NULLIF($4, '')::uuid) RETURNING "+walletColumns,
+		ownerID, externalRef, currency, clientID))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		r.lg.ErrorCtx(ctx, "func createwallet external reference already exists")
+		return nil, errExternalRefExists
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "func createwallet sql query failed")
+		return nil, err
+	}
+	return wallet, nil
+}
+
+func (r *Repository) GetWallet(walletID string, ctx context.Context) (*Wallet, error) {
+	wallet, err := scanWallet(r.db.QueryRow(ctx, "SELECT "+walletColumns+" FROM wallets WHERE id = $1", walletID))
+	if err == pgx.ErrNoRows {
+		r.lg.ErrorCtx(ctx, "func getwallet walletid not found")
+		return nil, errWalletid
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "Could not scan wallet")
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// GetWalletOwners returns the owner of each wallet in walletIDs, empty for wallets
+// without one. Unknown wallets are missing from the map.
+func (r *Repository) GetWalletOwners(walletIDs []string, ctx context.Context) (map[string]string, error) {
+	rows, err := r.db.Query(ctx, "SELECT id::text, COALESCE(owner_id, '') FROM wallets WHERE id IN (SELECT unnest($1::text[])::uuid)", walletIDs)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func getwalletowners sql query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[string]string, len(walletIDs))
+	for rows.Next() {
+		var id, ownerID string
+		if err := rows.Scan(&id, &ownerID); err != nil {
+			r.lg.ErrorCtx(ctx, "Could not scan wallet owner")
+			return nil, err
+		}
+		owners[id] = ownerID
+	}
+	if err := rows.Err(); err != nil {
+		r.lg.ErrorCtx(ctx, "func getwalletowners rows failed")
+		return nil, err
+	}
+	return owners, nil
+}
+
+// CloseWallet soft-closes an empty wallet. The row is kept so its ledger stays auditable.
+func (r *Repository) CloseWallet(walletID string, ctx context.Context) (*Wallet, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func closewallet begin transaction failed")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	wallet, err := scanWallet(tx.QueryRow(ctx, "SELECT "+walletColumns+" FROM wallets WHERE id = $1 FOR UPDATE", walletID))
+	if err == pgx.ErrNoRows {
+		r.lg.ErrorCtx(ctx, "func closewallet walletid not found")
+		return nil, errWalletid
+	} else if err != nil {
+		r.lg.ErrorCtx(ctx, "Could not scan wallet")
+		return nil, err
+	}
+	if wallet.Status == CLOSED {
+		return nil, errWalletClosed
+	}
+	if wallet.Balance != 0 {
+		return nil, errWalletNotEmpty
+	}
+
+	clientID, _ := requestctx.ClientIDFromContext(ctx)
+	wallet, err = scanWallet(tx.QueryRow(ctx, "UPDATE wallets SET status = $1, closed_at = now(), closed_by_client_id = NULLIF($3, '')::uuid WHERE id = $2 RETURNING "+walletColumns, CLOSED, walletID, clientID))
+	if err != nil {
+		r.lg.ErrorCtx(ctx, "func closewallet sql query failed")
+		return nil, err
+	}
+	if err := commitTx(tx, ctx); err != nil {
+		r.lg.ErrorCtx(ctx, "func closewallet commit failed")
+		return nil, err
+	}
+	return wallet, nil
+}
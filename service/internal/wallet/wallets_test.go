@@ -0,0 +1,264 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/requestctx"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWalletLifecycleHandlers(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, lg: mockLogger}
+
+	r := chi.NewRouter()
+	r.Post("/wallets", handler.CreateWallet)
+	r.Get("/wallets/{id}", handler.GetWallet)
+	r.Delete("/wallets/{id}", handler.CloseWallet)
+
+	wallet := &Wallet{ID: "123", OwnerID: "owner", Status: ACTIVE, CreatedAt: time.Now()}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		mockRepoFunc   func()
+		mockLoggerFunc func()
+	}{
+		{
+			name:           "Create Wallet",
+			method:         http.MethodPost,
+			url:            "/wallets",
+			body:           `{"ownerId":"owner","externalRef":"ref-1"}`,
+			expectedStatus: http.StatusCreated,
+			mockRepoFunc: func() {
+				mockRepo.On("CreateWallet", "owner", "ref-1", defaultCurrency, mock.Anything).Return(wallet, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", "123").Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "wallet created").Return().Once()
+			},
+		},
+		{
+			name:           "Create Anonymous Wallet",
+			method:         http.MethodPost,
+			url:            "/wallets",
+			body:           "",
+			expectedStatus: http.StatusCreated,
+			mockRepoFunc: func() {
+				mockRepo.On("CreateWallet", "", "", defaultCurrency, mock.Anything).Return(wallet, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", "123").Return(mockLogger).Once()
+				mockLogger.On("InfoCtx", mock.Anything, "wallet created").Return().Once()
+			},
+		},
+		{
+			name:           "Create Wallet Duplicate External Reference",
+			method:         http.MethodPost,
+			url:            "/wallets",
+			body:           `{"externalRef":"ref-1"}`,
+			expectedStatus: http.StatusConflict,
+			mockRepoFunc: func() {
+				mockRepo.On("CreateWallet", "", "ref-1", defaultCurrency, mock.Anything).Return((*Wallet)(nil), errExternalRefExists).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "external reference already exists").Return().Once()
+			},
+		},
+		{
+			name:           "Get Wallet",
+			method:         http.MethodGet,
+			url:            "/wallets/" + walletA,
+			expectedStatus: http.StatusOK,
+			mockRepoFunc: func() {
+				mockRepo.On("GetWallet", walletA, mock.Anything).Return(wallet, nil).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "getting wallet").Return().Once()
+				mockLogger.On("InfoCtx", mock.Anything, "wallet retrieved").Return().Once()
+			},
+		},
+		{
+			name:           "Close Wallet With Money",
+			method:         http.MethodDelete,
+			url:            "/wallets/" + walletA,
+			expectedStatus: http.StatusConflict,
+			mockRepoFunc: func() {
+				mockRepo.On("CloseWallet", walletA, mock.Anything).Return((*Wallet)(nil), errWalletNotEmpty).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletA).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "closing wallet").Return().Once()
+				mockLogger.On("With", "error", errWalletNotEmpty).Return(mockLogger).Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "wallet cannot be closed").Return().Once()
+			},
+		},
+		{
+			name:           "Close Unknown Wallet",
+			method:         http.MethodDelete,
+			url:            "/wallets/" + walletB,
+			expectedStatus: http.StatusNotFound,
+			mockRepoFunc: func() {
+				mockRepo.On("CloseWallet", walletB, mock.Anything).Return((*Wallet)(nil), errWalletid).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("With", "walletId", walletB).Return(mockLogger).Once()
+				mockLogger.On("DebugCtx", mock.Anything, "closing wallet").Return().Once()
+				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return().Once()
+			},
+		},
+		{
+			name:           "Malformed Wallet ID",
+			method:         http.MethodDelete,
+			url:            "/wallets/not-a-uuid",
+			expectedStatus: http.StatusNotFound,
+			mockRepoFunc:   func() {},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "walletid not found").Return().Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockRepoFunc()
+			tt.mockLoggerFunc()
+
+			req := httptest.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			res := w.Result()
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+
+			mockRepo.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_CloseWallet(t *testing.T) {
+	mockLogger := new(MockLogger)
+
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const (
+		lockQuery   = "SELECT " + walletColumns + " FROM wallets WHERE id = $1 FOR UPDATE"
+		updateQuery = "UPDATE wallets SET status = $1, closed_at = now(), closed_by_client_id = NULLIF($3, '')::uuid WHERE id = $2 RETURNING " + walletColumns
+	)
+	createdAt := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC)
+	closedAt := createdAt.Add(time.Hour)
+	walletRow := func(status string, balance int64, closedAt *time.Time) *mockRow {
+		return &mockRow{values: []any{"123", "", "", status, balance, "RUB", createdAt, closedAt}}
+	}
+
+	tests := []struct {
+		name           string
+		mockSetup      func(tx *MockTx)
+		mockLoggerFunc func()
+		expectedWallet *Wallet
+		expectedErr    error
+	}{
+		{
+			name: "Successful Close",
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockQuery, "123").Return(walletRow(ACTIVE, 0, nil)).Once()
+				tx.On("QueryRow", mock.Anything, updateQuery, CLOSED, "123", adminClient.ID).Return(walletRow(CLOSED, 0, &closedAt)).Once()
+				tx.On("Commit", mock.Anything).Return(nil).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedWallet: &Wallet{ID: "123", Status: CLOSED, Currency: "RUB", CreatedAt: createdAt, ClosedAt: &closedAt},
+			expectedErr:    nil,
+		},
+		{
+			name: "Wallet Holds Money",
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockQuery, "123").Return(walletRow(ACTIVE, 10, nil)).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedWallet: nil,
+			expectedErr:    errWalletNotEmpty,
+		},
+		{
+			name: "Wallet Already Closed",
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockQuery, "123").Return(walletRow(CLOSED, 0, &closedAt)).Once()
+			},
+			mockLoggerFunc: func() {},
+			expectedWallet: nil,
+			expectedErr:    errWalletClosed,
+		},
+		{
+			name: "Database Error",
+			mockSetup: func(tx *MockTx) {
+				tx.On("QueryRow", mock.Anything, lockQuery, "123").Return(&mockRow{err: errors.New("db error")}).Once()
+			},
+			mockLoggerFunc: func() {
+				mockLogger.On("ErrorCtx", mock.Anything, "Could not scan wallet").Return().Once()
+			},
+			expectedWallet: nil,
+			expectedErr:    errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTx := new(MockTx)
+			mockTx.On("Rollback", mock.Anything).Return(nil).Maybe()
+			mockPool.On("Begin", mock.Anything).Return(mockTx, nil).Once()
+			tt.mockSetup(mockTx)
+			tt.mockLoggerFunc()
+
+			wallet, err := repo.CloseWallet("123", requestctx.WithClientID(context.Background(), adminClient.ID))
+
+			assert.Equal(t, tt.expectedWallet, wallet)
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockPool.AssertExpectations(t)
+			mockTx.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRepository_GetWalletOwners(t *testing.T) {
+	mockLogger := new(MockLogger)
+	mockPool := new(MockPool)
+	repo := &Repository{db: mockPool, lg: mockLogger}
+
+	const query = "SELECT id::text, COALESCE(owner_id, '') FROM wallets WHERE id IN (SELECT unnest($1::text[])::uuid)"
+	ids := []string{walletA, walletB, walletC}
+
+	rows := &mockRows{rows: [][]any{{walletA, "user-1"}, {walletB, ""}}}
+	mockPool.On("Query", mock.Anything, query, ids).Return(rows, nil).Once()
+	owners, err := repo.GetWalletOwners(ids, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{walletA: "user-1", walletB: ""}, owners)
+
+	mockPool.On("Query", mock.Anything, query, ids).Return((*mockRows)(nil), errors.New("db error")).Once()
+	mockLogger.On("ErrorCtx", mock.Anything, "func getwalletowners sql query failed").Return().Once()
+	_, err = repo.GetWalletOwners(ids, context.Background())
+	assert.Error(t, err)
+
+	mockPool.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
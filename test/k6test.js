@@ -13,6 +13,8 @@ export const options = {
 // Уникальный ID кошелька
 const walletID = 'b49c66cb-90f8-4ad7-b2b3-fd993e9d9efd'; // Замените на реальный UUID из вашего приложения
 const baseURL = 'http://localhost:8080/api/v1';          // URL вашего приложения
+// API-ключ клиента со скоупами deposit, withdraw и read-balance: k6 run -e API_KEY=... k6test.js
+const apiKey = __ENV.API_KEY;
 
 // Основная функция теста
 export default function () {
@@ -22,7 +24,7 @@ export default function () {
         operationType: 'DEPOSIT',
         amount: 10,
     });
-    const depositHeaders = { 'Content-Type': 'application/json' };
+    const depositHeaders = { 'Content-Type': 'application/json', 'X-API-Key': apiKey };
 
     const depositResponse = http.post(`${baseURL}/wallet`, depositPayload, { headers: depositHeaders });
     check(depositResponse, {
@@ -35,7 +37,7 @@ export default function () {
         amount: 5,
     });
 
-    const withdrawHeaders = { 'Content-Type': 'application/json' };
+    const withdrawHeaders = { 'Content-Type': 'application/json', 'X-API-Key': apiKey };
 
     const withdrawResponse = http.post(`${baseURL}/wallet`, withdrawPayload, { headers: withdrawHeaders });
     check(withdrawResponse, {
@@ -43,10 +45,10 @@ export default function () {
     });
 
     // Тестируем получение баланса
-    const balanceResponse = http.get(`${baseURL}/balance/${walletID}`);
+    const balanceResponse = http.get(`${baseURL}/balance/${walletID}`, { headers: { 'X-API-Key': apiKey } });
     check(balanceResponse, {
         'Get balance status is 200': (r) => r.status === 200,
-        'Balance contains amount': (r) => r.json('balance') >= 0,
+        'Balance contains amount': (r) => r.json('available') >= 0,
     });
 
     // Задержка между запросами для имитации реального поведения